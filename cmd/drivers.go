@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/zdnscloud/elb-controller/driver"
+	"github.com/zdnscloud/elb-controller/lbctrl"
+
+	"github.com/zdnscloud/cement/log"
+)
+
+// driversConfig is the content of -driver-config file, Default empty means the first driver
+type driversConfig struct {
+	Default string           `json:"default"`
+	Drivers []driverInstance `json:"drivers"`
+}
+
+type driverInstance struct {
+	Name    string         `json:"name"`
+	Driver  string         `json:"driver"`
+	Options driver.Options `json:"options"`
+}
+
+func loadDriversConfig(path string) (*driversConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c driversConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("parse driver config %s failed %s", path, err.Error())
+	}
+	if len(c.Drivers) == 0 {
+		return nil, fmt.Errorf("driver config %s has no driver", path)
+	}
+	if c.Default == "" {
+		c.Default = c.Drivers[0].Name
+	}
+	return &c, nil
+}
+
+// createDrivers creates the drivers of driverConfig file, or a single default driver
+// by -driver and -driver-opt if the file isn't specified
+func createDrivers() (*lbctrl.Drivers, error) {
+	c := &driversConfig{
+		Default: lbctrl.DefaultDriverName,
+		Drivers: []driverInstance{{Name: lbctrl.DefaultDriverName, Driver: driverName, Options: genDriverOptions()}},
+	}
+	if driverConfig != "" {
+		var err error
+		if c, err = loadDriversConfig(driverConfig); err != nil {
+			return nil, err
+		}
+	}
+
+	drivers := make(map[string]driver.Driver)
+	if err := newDrivers(c.Drivers, drivers); err != nil {
+		closeDrivers(drivers)
+		return nil, err
+	}
+	lbDrivers, err := lbctrl.NewDrivers(c.Default, drivers)
+	if err != nil {
+		closeDrivers(drivers)
+		return nil, err
+	}
+	return lbDrivers, nil
+}
+
+func newDrivers(instances []driverInstance, drivers map[string]driver.Driver) error {
+	for _, instance := range instances {
+		if instance.Name == "" {
+			return fmt.Errorf("driver name is empty")
+		}
+		if _, ok := drivers[instance.Name]; ok {
+			return fmt.Errorf("duplicate driver %s", instance.Name)
+		}
+		d, err := driver.New(instance.Driver, instance.Options)
+		if err != nil {
+			return fmt.Errorf("create driver %s failed %s", instance.Name, err.Error())
+		}
+		log.Infof("Driver %s info:%s", instance.Name, d.Version())
+		drivers[instance.Name] = d
+	}
+	return nil
+}
+
+func closeDrivers(drivers map[string]driver.Driver) {
+	for _, d := range drivers {
+		if closer, ok := d.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
@@ -3,8 +3,13 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"time"
 
+	"github.com/zdnscloud/elb-controller/driver"
+	_ "github.com/zdnscloud/elb-controller/driver/plugin"
 	"github.com/zdnscloud/elb-controller/driver/radware"
+	_ "github.com/zdnscloud/elb-controller/driver/testdriver"
 	"github.com/zdnscloud/elb-controller/lbctrl"
 
 	"github.com/zdnscloud/cement/log"
@@ -39,23 +44,55 @@ func createK8SClient() (cache.Cache, client.Client, *rest.Config, error) {
 }
 
 var (
-	masterServer string
-	backupServer string
-	user         string
-	password     string
-	cluster      string
-	version      string
-	build        string
-	showVersion  bool
+	driverName     string
+	driverOpts     = driver.Options{}
+	masterServer   string
+	backupServer   string
+	user           string
+	password       string
+	cluster        string
+	version        string
+	build          string
+	showVersion    bool
+	taskTimeout    time.Duration
+	maxRetryDelay  time.Duration
+	resyncInterval time.Duration
+	metricsAddr    string
+	workers        int
+	dryRun         bool
+	driverConfig   string
 )
 
+// genDriverOptions keeps the legacy radware flags working, options set by -driver-opt take precedence
+func genDriverOptions() driver.Options {
+	opts := driver.Options{
+		radware.MasterServerOption: masterServer,
+		radware.BackupServerOption: backupServer,
+		radware.UserOption:         user,
+		radware.PasswordOption:     password,
+	}
+	for k, v := range driverOpts {
+		opts[k] = v
+	}
+	return opts
+}
+
 func main() {
 
+	flag.StringVar(&driverName, "driver", radware.DriverName, fmt.Sprintf("external loadbalancer driver, one of %v", driver.Drivers()))
+	flag.Var(driverOpts, "driver-opt", "driver specific option in key=value format, can be repeated")
+	flag.StringVar(&driverConfig, "driver-config", "", "json file of named driver instances, services select one by annotation, -driver and -driver-opt are ignored if it's set")
 	flag.StringVar(&masterServer, "masterserver", "", "master external loadbalancer managerment address")
 	flag.StringVar(&backupServer, "backupserver", "", "backup external loadbalancer managerment address")
 	flag.StringVar(&user, "user", "admin", "external loadbalancer user")
 	flag.StringVar(&password, "password", "zcloud", "external loadbalancer password")
 	flag.StringVar(&cluster, "cluster", "local", "zcloud kubernetes cluster name")
+	flag.DurationVar(&taskTimeout, "task-timeout", lbctrl.DefaultTaskTimeout, "timeout of each external loadbalancer task")
+	flag.DurationVar(&maxRetryDelay, "max-retry-delay", lbctrl.DefaultMaxRetryDelay, "max backoff delay of failed task, it's also the retry interval of service failed too many times")
+	flag.IntVar(&workers, "workers", lbctrl.DefaultWorkers, "count of workers processing loadbalancer tasks concurrently, tasks of the same service are never processed concurrently")
+	flag.DurationVar(&resyncInterval, "resync-interval", lbctrl.DefaultResyncInterval, "interval of re-applying configs of all services to correct changes made on loadbalancer by others, 0 means disabled")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "listen address of metrics http server, metrics are served on /debug/vars, empty means disabled")
+	flag.BoolVar(&dryRun, "dry-run", false, "only log the planned loadbalancer operations and send them as service events, loadbalancer isn't changed")
 	flag.BoolVar(&showVersion, "version", false, "show version")
 	flag.Parse()
 
@@ -71,12 +108,31 @@ func main() {
 		log.Fatalf("Create cache failed:%s", err.Error())
 	}
 
-	driver := radware.New(masterServer, backupServer, user, password)
-	log.Infof("Driver info:%s", driver.Version())
+	if metricsAddr != "" {
+		go func() {
+			if err := http.ListenAndServe(metricsAddr, nil); err != nil {
+				log.Errorf("metrics server failed %s", err.Error())
+			}
+		}()
+	}
+
+	drivers, err := createDrivers()
+	if err != nil {
+		log.Fatalf("Create driver failed:%s", err.Error())
+	}
 
-	_, err = lbctrl.New(cli, cache, config, cluster, driver)
+	ctrl, err := lbctrl.New(cli, cache, config, cluster, drivers, lbctrl.Options{
+		TaskTimeout:    taskTimeout,
+		MaxRetryDelay:  maxRetryDelay,
+		ResyncInterval: resyncInterval,
+		Workers:        workers,
+		DryRun:         dryRun,
+	})
 	if err != nil {
 		log.Fatalf("new controller failed %s", err.Error())
 	}
-	signal.WaitForInterrupt(nil)
+	signal.WaitForInterrupt(func() {
+		ctrl.Stop()
+		drivers.Close()
+	})
 }
@@ -1,42 +1,190 @@
 package driver
 
 import (
+	"context"
 	"encoding/json"
 )
 
 type Protocol string
 type LoadBalanceMethod string
+type Feature string
+type HealthCheckType string
+type PersistenceType string
+type BackendMode string
 
 const (
-	ProtocolTCP Protocol = "tcp"
-	ProtocolUDP Protocol = "udp"
+	ProtocolTCP  Protocol = "tcp"
+	ProtocolUDP  Protocol = "udp"
+	ProtocolSCTP Protocol = "sctp"
 
 	LBMethodRoundRobin       LoadBalanceMethod = "rr"
 	LBMethodLeastConnections LoadBalanceMethod = "lc"
 	LBMethodHash             LoadBalanceMethod = "hash"
+
+	FeatureIPv6                Feature = "ipv6"
+	FeatureClientIPPersistence Feature = "clientip-persistence"
+	FeatureCookiePersistence   Feature = "cookie-persistence"
+	FeatureTLSOffload          Feature = "tls-offload"
+	FeatureSourceRanges        Feature = "source-ranges"
+	FeaturePodBackend          Feature = "pod-backend"
+	FeatureBackendDrain        Feature = "backend-drain"
+
+	HealthCheckTCP   HealthCheckType = "tcp"
+	HealthCheckUDP   HealthCheckType = "udp"
+	HealthCheckHTTP  HealthCheckType = "http"
+	HealthCheckHTTPS HealthCheckType = "https"
+	HealthCheckICMP  HealthCheckType = "icmp"
+
+	PersistenceNone     PersistenceType = "none"
+	PersistenceClientIP PersistenceType = "clientip"
+	PersistenceCookie   PersistenceType = "cookie"
+
+	// BackendModeNode backends are node ips and node ports, BackendModePod backends are pod ips and target ports
+	BackendModeNode BackendMode = "node"
+	BackendModePod  BackendMode = "pod"
 )
 
 type Driver interface {
-	Create(Config) error
-	Update(old, new Config) error
-	Delete(Config) error
+	Create(ctx context.Context, c Config) error
+	Update(ctx context.Context, old, new Config) error
+	Delete(ctx context.Context, c Config) error
+	// Plan returns the ordered operations which would be applied without applying them,
+	// old nil means create new, new nil means delete old
+	Plan(ctx context.Context, old, new *Config) ([]Operation, error)
+	// Inventory returns the configs of k8sCluster which are actually programmed on the loadbalancer
+	Inventory(ctx context.Context, k8sCluster string) ([]Config, error)
+	Capabilities() Capabilities
 	Version() string
 }
 
+type Capabilities struct {
+	Protocols    []Protocol          `json:"protocols"`
+	Methods      []LoadBalanceMethod `json:"methods"`
+	Features     []Feature           `json:"features"`
+	HealthChecks []HealthCheckType   `json:"healthChecks"`
+}
+
+func (c Capabilities) SupportProtocol(p Protocol) bool {
+	for _, s := range c.Protocols {
+		if s == p {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) SupportMethod(m LoadBalanceMethod) bool {
+	for _, s := range c.Methods {
+		if s == m {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) SupportFeature(f Feature) bool {
+	for _, s := range c.Features {
+		if s == f {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Capabilities) SupportHealthCheck(t HealthCheckType) bool {
+	for _, s := range c.HealthChecks {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Config VIP is the ipv4 vip and VIPv6 is the ipv6 vip, at least one of them is set,
+// both are set for dual-stack service
 type Config struct {
 	K8sCluster   string            `json:"k8sCluster"`
 	K8sNamespace string            `json:"k8sNamespace"`
 	K8sService   string            `json:"k8sService"`
 	VIP          string            `json:"vip"`
+	VIPv6        string            `json:"vipv6,omitempty"`
 	Method       LoadBalanceMethod `json:"method"`
 	Services     []Service         `json:"services"`
+	// Persistence nil means no session persistence
+	Persistence *Persistence `json:"persistence,omitempty"`
+	// SourceRanges are the allowed client cidrs, empty means all
+	SourceRanges []string `json:"sourceRanges,omitempty"`
+	// BackendMode empty means BackendModeNode
+	BackendMode BackendMode `json:"backendMode,omitempty"`
+	// DrainSeconds is how long removed backends stop accepting new connections before
+	// they are deleted, 0 means deleted immediately, it's only used by update
+	DrainSeconds int32 `json:"drainSeconds,omitempty"`
+}
+
+// Persistence binds requests from the same client to the same backend, Timeout is in seconds
+type Persistence struct {
+	Type       PersistenceType `json:"type"`
+	Timeout    int32           `json:"timeout,omitempty"`
+	CookieName string          `json:"cookieName,omitempty"`
 }
 
+// Service BackendHosts are ipv4 addresses serving VIP and BackendHostsV6 are ipv6 addresses serving VIPv6
 type Service struct {
-	Port         int32    `json:"port"`
-	BackendPort  int32    `json:"backendPort"`
-	BackendHosts []string `json:"backendHosts"`
-	Protocol     Protocol `json:"protocol"`
+	Port           int32    `json:"port"`
+	BackendPort    int32    `json:"backendPort"`
+	BackendHosts   []string `json:"backendHosts"`
+	BackendHostsV6 []string `json:"backendHostsV6,omitempty"`
+	// BackendWeights is keyed by backend host, host not in it has weight 1
+	BackendWeights map[string]int32 `json:"backendWeights,omitempty"`
+	Protocol       Protocol         `json:"protocol"`
+	// HealthCheck nil means using driver default health check of the protocol
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+	// TLS not nil means tls is terminated on the loadbalancer
+	TLS *TLS `json:"tls,omitempty"`
+}
+
+// TLS Certificate and Key are pem encoded, they may be empty in delete config
+// when the secret has been deleted
+type TLS struct {
+	SecretName  string `json:"secretName"`
+	Certificate string `json:"certificate,omitempty"`
+	Key         string `json:"key,omitempty"`
+}
+
+// HealthCheck zero value fields mean using driver default, time is in seconds
+type HealthCheck struct {
+	Type           HealthCheckType `json:"type"`
+	Path           string          `json:"path,omitempty"`
+	ExpectedStatus int             `json:"expectedStatus,omitempty"`
+	Interval       int32           `json:"interval,omitempty"`
+	Timeout        int32           `json:"timeout,omitempty"`
+	Retries        int32           `json:"retries,omitempty"`
+}
+
+func (c Config) VIPs() []string {
+	vips := []string{}
+	if c.VIP != "" {
+		vips = append(vips, c.VIP)
+	}
+	if c.VIPv6 != "" {
+		vips = append(vips, c.VIPv6)
+	}
+	return vips
+}
+
+// Redact returns a copy of c without private keys, it should be used for logging
+func (c Config) Redact() Config {
+	services := make([]Service, 0, len(c.Services))
+	for _, s := range c.Services {
+		if s.TLS != nil && s.TLS.Key != "" {
+			tls := *s.TLS
+			tls.Key = "******"
+			s.TLS = &tls
+		}
+		services = append(services, s)
+	}
+	c.Services = services
+	return c
 }
 
 func (c Config) ToJson() string {
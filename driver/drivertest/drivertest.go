@@ -0,0 +1,147 @@
+// Package drivertest is a conformance suite of driver.Driver, it runs the same
+// scenarios against any driver and checks the state observed after each step,
+// a driver test can simply call drivertest.Run(t, d, drivertest.InventoryInspector(d))
+package drivertest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/zdnscloud/elb-controller/driver"
+)
+
+const (
+	DefaultCluster   = "drivertest"
+	DefaultNamespace = "drivertest"
+	DefaultTimeout   = 3 * time.Minute
+)
+
+// Inspector returns the config of the k8s service actually programmed by the driver, nil means nothing
+type Inspector interface {
+	Inspect(ctx context.Context, k8sCluster, k8sNamespace, k8sService string) (*driver.Config, error)
+}
+
+type InspectorFunc func(ctx context.Context, k8sCluster, k8sNamespace, k8sService string) (*driver.Config, error)
+
+func (f InspectorFunc) Inspect(ctx context.Context, k8sCluster, k8sNamespace, k8sService string) (*driver.Config, error) {
+	return f(ctx, k8sCluster, k8sNamespace, k8sService)
+}
+
+// InventoryInspector inspects state by driver Inventory
+func InventoryInspector(d driver.Driver) Inspector {
+	return InspectorFunc(func(ctx context.Context, k8sCluster, k8sNamespace, k8sService string) (*driver.Config, error) {
+		configs, err := d.Inventory(ctx, k8sCluster)
+		if err != nil {
+			return nil, err
+		}
+		for i := range configs {
+			if configs[i].K8sNamespace == k8sNamespace && configs[i].K8sService == k8sService {
+				return &configs[i], nil
+			}
+		}
+		return nil, nil
+	})
+}
+
+// Suite zero value fields use defaults, VIPs and Backends should be addresses the
+// loadbalancer accepts, the defaults are from documentation ranges
+type Suite struct {
+	Driver    driver.Driver
+	Inspector Inspector
+	Cluster   string
+	Namespace string
+	// VIPs needs at least two addresses for vip change scenario
+	VIPs []string
+	// Backends needs at least three addresses for backend scenarios
+	Backends []string
+	// Timeout of each scenario
+	Timeout time.Duration
+}
+
+type Result struct {
+	Scenario string
+	Skipped  bool
+	Err      error
+}
+
+func (r Result) String() string {
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("%s skipped", r.Scenario)
+	case r.Err != nil:
+		return fmt.Sprintf("%s failed %s", r.Scenario, r.Err.Error())
+	default:
+		return fmt.Sprintf("%s succeed", r.Scenario)
+	}
+}
+
+// Run runs every scenario as a subtest of t
+func Run(t *testing.T, d driver.Driver, inspector Inspector) {
+	s := &Suite{
+		Driver:    d,
+		Inspector: inspector,
+	}
+	for _, sc := range scenarios {
+		sc := sc
+		t.Run(sc.name, func(t *testing.T) {
+			r := s.runScenario(context.Background(), sc)
+			if r.Skipped {
+				t.Skip(r.String())
+			}
+			if r.Err != nil {
+				t.Fatal(r.String())
+			}
+		})
+	}
+}
+
+// Run runs every scenario and returns their results in order
+func (s *Suite) Run(ctx context.Context) []Result {
+	results := make([]Result, 0, len(scenarios))
+	for _, sc := range scenarios {
+		results = append(results, s.runScenario(ctx, sc))
+	}
+	return results
+}
+
+func (s *Suite) runScenario(ctx context.Context, sc scenario) Result {
+	s.setDefaults()
+	r := Result{Scenario: sc.name}
+	if sc.skip != nil && sc.skip(s.Driver.Capabilities()) {
+		r.Skipped = true
+		return r
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	env := &env{suite: s, name: sc.name}
+	r.Err = sc.run(ctx, env)
+	// cleanup doesn't overwrite the scenario error
+	if err := env.cleanup(ctx); err != nil && r.Err == nil {
+		r.Err = fmt.Errorf("cleanup failed %s", err.Error())
+	}
+	return r
+}
+
+func (s *Suite) setDefaults() {
+	if s.Cluster == "" {
+		s.Cluster = DefaultCluster
+	}
+	if s.Namespace == "" {
+		s.Namespace = DefaultNamespace
+	}
+	if len(s.VIPs) < 2 {
+		s.VIPs = []string{"192.0.2.10", "192.0.2.11"}
+	}
+	if len(s.Backends) < 3 {
+		s.Backends = []string{"198.51.100.1", "198.51.100.2", "198.51.100.3"}
+	}
+	if s.Timeout <= 0 {
+		s.Timeout = DefaultTimeout
+	}
+	if s.Inspector == nil {
+		s.Inspector = InventoryInspector(s.Driver)
+	}
+}
@@ -0,0 +1,259 @@
+package drivertest
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/zdnscloud/elb-controller/driver"
+)
+
+type scenario struct {
+	name string
+	// skip returns true if the driver doesn't support the scenario
+	skip func(driver.Capabilities) bool
+	run  func(context.Context, *env) error
+}
+
+var scenarios = []scenario{
+	{
+		name: "create",
+		run: func(ctx context.Context, e *env) error {
+			c := e.baseConfig()
+			return e.create(ctx, c)
+		},
+	},
+	{
+		name: "add-port",
+		run: func(ctx context.Context, e *env) error {
+			old := e.baseConfig()
+			new := e.baseConfig()
+			new.Services = append(new.Services, e.service(443, 30443, 2))
+			return e.createAndUpdate(ctx, old, new)
+		},
+	},
+	{
+		name: "remove-port",
+		run: func(ctx context.Context, e *env) error {
+			old := e.baseConfig()
+			old.Services = append(old.Services, e.service(443, 30443, 2))
+			new := e.baseConfig()
+			return e.createAndUpdate(ctx, old, new)
+		},
+	},
+	{
+		name: "add-backend",
+		run: func(ctx context.Context, e *env) error {
+			old := e.baseConfig()
+			new := e.baseConfig()
+			new.Services = []driver.Service{e.service(80, 30080, 3)}
+			return e.createAndUpdate(ctx, old, new)
+		},
+	},
+	{
+		name: "remove-backend",
+		run: func(ctx context.Context, e *env) error {
+			old := e.baseConfig()
+			new := e.baseConfig()
+			new.Services = []driver.Service{e.service(80, 30080, 1)}
+			return e.createAndUpdate(ctx, old, new)
+		},
+	},
+	{
+		name: "vip-change",
+		run: func(ctx context.Context, e *env) error {
+			old := e.baseConfig()
+			new := e.baseConfig()
+			new.VIP = e.suite.VIPs[1]
+			return e.createAndUpdate(ctx, old, new)
+		},
+	},
+	{
+		name: "method-change",
+		skip: func(caps driver.Capabilities) bool {
+			return !caps.SupportMethod(driver.LBMethodLeastConnections)
+		},
+		run: func(ctx context.Context, e *env) error {
+			old := e.baseConfig()
+			new := e.baseConfig()
+			new.Method = driver.LBMethodLeastConnections
+			return e.createAndUpdate(ctx, old, new)
+		},
+	},
+	{
+		name: "idempotent-create",
+		run: func(ctx context.Context, e *env) error {
+			c := e.baseConfig()
+			if err := e.create(ctx, c); err != nil {
+				return err
+			}
+			return e.create(ctx, c)
+		},
+	},
+	{
+		name: "idempotent-update",
+		run: func(ctx context.Context, e *env) error {
+			c := e.baseConfig()
+			return e.createAndUpdate(ctx, c, c)
+		},
+	},
+	{
+		name: "delete",
+		run: func(ctx context.Context, e *env) error {
+			c := e.baseConfig()
+			if err := e.create(ctx, c); err != nil {
+				return err
+			}
+			return e.delete(ctx, c)
+		},
+	},
+	{
+		name: "delete-missing",
+		run: func(ctx context.Context, e *env) error {
+			return e.delete(ctx, e.baseConfig())
+		},
+	},
+}
+
+// env runs the steps of a scenario on a k8s service named after the scenario
+type env struct {
+	suite   *Suite
+	name    string
+	applied *driver.Config
+}
+
+func (e *env) baseConfig() driver.Config {
+	return driver.Config{
+		K8sCluster:   e.suite.Cluster,
+		K8sNamespace: e.suite.Namespace,
+		K8sService:   e.name,
+		VIP:          e.suite.VIPs[0],
+		Method:       driver.LBMethodRoundRobin,
+		Services:     []driver.Service{e.service(80, 30080, 2)},
+	}
+}
+
+// service returns a tcp service with the first backendCount backends
+func (e *env) service(port, backendPort int32, backendCount int) driver.Service {
+	return driver.Service{
+		Port:         port,
+		BackendPort:  backendPort,
+		BackendHosts: append([]string{}, e.suite.Backends[:backendCount]...),
+		Protocol:     driver.ProtocolTCP,
+	}
+}
+
+func (e *env) create(ctx context.Context, c driver.Config) error {
+	if err := e.suite.Driver.Create(ctx, c); err != nil {
+		return fmt.Errorf("create failed %s", err.Error())
+	}
+	e.applied = &c
+	return e.expect(ctx, &c)
+}
+
+func (e *env) createAndUpdate(ctx context.Context, old, new driver.Config) error {
+	if err := e.create(ctx, old); err != nil {
+		return err
+	}
+	if err := e.suite.Driver.Update(ctx, old, new); err != nil {
+		return fmt.Errorf("update failed %s", err.Error())
+	}
+	e.applied = &new
+	return e.expect(ctx, &new)
+}
+
+// delete of config which doesn't exist may succeed or return not found error
+func (e *env) delete(ctx context.Context, c driver.Config) error {
+	if err := e.suite.Driver.Delete(ctx, c); err != nil && driver.ErrorTypeOf(err) != driver.ErrorNotFound {
+		return fmt.Errorf("delete failed %s", err.Error())
+	}
+	e.applied = nil
+	return e.expect(ctx, nil)
+}
+
+func (e *env) cleanup(ctx context.Context) error {
+	if e.applied == nil {
+		return nil
+	}
+	if err := e.suite.Driver.Delete(ctx, *e.applied); err != nil && driver.ErrorTypeOf(err) != driver.ErrorNotFound {
+		return err
+	}
+	return nil
+}
+
+// expect checks the observed state is the same as c, nil c means nothing is programmed
+func (e *env) expect(ctx context.Context, c *driver.Config) error {
+	observed, err := e.suite.Inspector.Inspect(ctx, e.suite.Cluster, e.suite.Namespace, e.name)
+	if err != nil {
+		return fmt.Errorf("inspect failed %s", err.Error())
+	}
+	if c == nil || observed == nil {
+		if c != observed {
+			return fmt.Errorf("expect %s but observed %s", toJson(c), toJson(observed))
+		}
+		return nil
+	}
+	if !reflect.DeepEqual(normalize(*c), normalize(*observed)) {
+		return fmt.Errorf("expect %s but observed %s", toJson(c), toJson(observed))
+	}
+	return nil
+}
+
+type normalizedService struct {
+	Port           int32
+	Protocol       driver.Protocol
+	BackendPort    int32
+	BackendHosts   []string
+	BackendHostsV6 []string
+}
+
+type normalizedConfig struct {
+	VIP      string
+	VIPv6    string
+	Method   driver.LoadBalanceMethod
+	Services []normalizedService
+}
+
+// normalize keeps the fields every driver should be able to observe, and sorts
+// them so that the order doesn't matter
+func normalize(c driver.Config) normalizedConfig {
+	result := normalizedConfig{
+		VIP:      c.VIP,
+		VIPv6:    c.VIPv6,
+		Method:   c.Method,
+		Services: []normalizedService{},
+	}
+	if result.Method == "" {
+		result.Method = driver.LBMethodRoundRobin
+	}
+	for _, s := range c.Services {
+		result.Services = append(result.Services, normalizedService{
+			Port:           s.Port,
+			Protocol:       s.Protocol,
+			BackendPort:    s.BackendPort,
+			BackendHosts:   sortedHosts(s.BackendHosts),
+			BackendHostsV6: sortedHosts(s.BackendHostsV6),
+		})
+	}
+	sort.Slice(result.Services, func(i, j int) bool {
+		if result.Services[i].Protocol != result.Services[j].Protocol {
+			return result.Services[i].Protocol < result.Services[j].Protocol
+		}
+		return result.Services[i].Port < result.Services[j].Port
+	})
+	return result
+}
+
+func sortedHosts(hosts []string) []string {
+	result := append([]string{}, hosts...)
+	sort.Strings(result)
+	return result
+}
+
+func toJson(c *driver.Config) string {
+	if c == nil {
+		return "nothing"
+	}
+	return c.Redact().ToJson()
+}
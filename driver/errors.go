@@ -0,0 +1,51 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+)
+
+type ErrorType string
+
+const (
+	// ErrorInvalidConfig is permanent, retrying the same config never succeeds
+	ErrorInvalidConfig ErrorType = "InvalidConfig"
+	// ErrorTransient is caused by temporary device or network problem and worth retrying
+	ErrorTransient   ErrorType = "Transient"
+	ErrorConflict    ErrorType = "Conflict"
+	ErrorNotFound    ErrorType = "NotFound"
+	ErrorAuthFailure ErrorType = "AuthFailure"
+	ErrorUnknown     ErrorType = "Unknown"
+)
+
+type Error struct {
+	Type ErrorType
+	Err  error
+}
+
+func NewError(t ErrorType, err error) error {
+	return &Error{
+		Type: t,
+		Err:  err,
+	}
+}
+
+func Errorf(t ErrorType, format string, args ...interface{}) error {
+	return NewError(t, fmt.Errorf(format, args...))
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func ErrorTypeOf(err error) ErrorType {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Type
+	}
+	return ErrorUnknown
+}
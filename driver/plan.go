@@ -0,0 +1,30 @@
+package driver
+
+import (
+	"fmt"
+)
+
+type OperationAction string
+
+const (
+	OperationCreate OperationAction = "create"
+	OperationUpdate OperationAction = "update"
+	OperationDelete OperationAction = "delete"
+)
+
+// Operation is a step a driver intends to apply on the loadbalancer
+type Operation struct {
+	Action OperationAction `json:"action"`
+	// Object is the driver specific object kind, like realserver of radware
+	Object string `json:"object"`
+	ID     string `json:"id"`
+	// Detail is an optional description of the desired object
+	Detail string `json:"detail,omitempty"`
+}
+
+func (o Operation) String() string {
+	if o.Detail == "" {
+		return fmt.Sprintf("%s %s %s", o.Action, o.Object, o.ID)
+	}
+	return fmt.Sprintf("%s %s %s %s", o.Action, o.Object, o.ID, o.Detail)
+}
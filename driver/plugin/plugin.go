@@ -0,0 +1,306 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zdnscloud/elb-controller/driver"
+
+	"github.com/zdnscloud/cement/log"
+)
+
+const (
+	DriverName = "plugin"
+
+	SocketOption         = "plugin-socket"
+	PathOption           = "plugin-path"
+	ArgsOption           = "plugin-args"
+	HealthIntervalOption = "plugin-health-interval"
+
+	defaultHealthInterval = 10 * time.Second
+	startTimeout          = 30 * time.Second
+	dialRetryInterval     = 500 * time.Millisecond
+)
+
+func init() {
+	driver.Register(DriverName, NewFromOptions)
+}
+
+// PluginDriver forwards driver calls to an external plugin process, if path
+// is set the plugin process is started and restarted by the driver, otherwise
+// the plugin is expected to be managed by others and listen on socket already;
+// every call uses its own connection, so a call abandoned by its context is
+// closed without affecting others
+type PluginDriver struct {
+	socket         string
+	path           string
+	args           []string
+	healthInterval time.Duration
+
+	lock      sync.Mutex
+	cmd       *exec.Cmd
+	connected bool
+	info      HandshakeResponse
+	stopCh    chan struct{}
+}
+
+func NewFromOptions(opts driver.Options) (driver.Driver, error) {
+	socket := opts.Get(SocketOption, "")
+	if socket == "" {
+		return nil, fmt.Errorf("plugin driver option %s is required", SocketOption)
+	}
+	interval := defaultHealthInterval
+	if v := opts.Get(HealthIntervalOption, ""); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("plugin driver option %s is invalid %s", HealthIntervalOption, err.Error())
+		}
+		interval = d
+	}
+	return New(socket, opts.Get(PathOption, ""), strings.Fields(opts.Get(ArgsOption, "")), interval)
+}
+
+func New(socket, path string, args []string, healthInterval time.Duration) (*PluginDriver, error) {
+	d := &PluginDriver{
+		socket:         socket,
+		path:           path,
+		args:           args,
+		healthInterval: healthInterval,
+		stopCh:         make(chan struct{}),
+	}
+
+	cmd, info, err := d.start()
+	if err != nil {
+		return nil, err
+	}
+	d.cmd = cmd
+	d.info = info
+	d.connected = true
+
+	go d.healthCheckLoop()
+	return d, nil
+}
+
+func (d *PluginDriver) Create(ctx context.Context, c driver.Config) error {
+	return d.call(ctx, createMethod, ConfigRequest{Deadline: getDeadline(ctx), Config: c.ToJson()}, &Empty{})
+}
+
+func (d *PluginDriver) Update(ctx context.Context, old, new driver.Config) error {
+	return d.call(ctx, updateMethod, UpdateRequest{Deadline: getDeadline(ctx), OldConfig: old.ToJson(), NewConfig: new.ToJson()}, &Empty{})
+}
+
+func (d *PluginDriver) Delete(ctx context.Context, c driver.Config) error {
+	return d.call(ctx, deleteMethod, ConfigRequest{Deadline: getDeadline(ctx), Config: c.ToJson()}, &Empty{})
+}
+
+func (d *PluginDriver) Plan(ctx context.Context, old, new *driver.Config) ([]driver.Operation, error) {
+	resp := &PlanResponse{}
+	if err := d.call(ctx, planMethod, PlanRequest{Deadline: getDeadline(ctx), OldConfig: configToJson(old), NewConfig: configToJson(new)}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Operations, nil
+}
+
+func (d *PluginDriver) Inventory(ctx context.Context, k8sCluster string) ([]driver.Config, error) {
+	resp := &InventoryResponse{}
+	if err := d.call(ctx, inventoryMethod, InventoryRequest{Deadline: getDeadline(ctx), K8sCluster: k8sCluster}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Configs, nil
+}
+
+func (d *PluginDriver) Capabilities() driver.Capabilities {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.info.Capabilities
+}
+
+func (d *PluginDriver) Version() string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return fmt.Sprintf("plugin %s %s (protocol v%d)", d.info.Name, d.info.Version, d.info.ProtocolVersion)
+}
+
+func (d *PluginDriver) Close() error {
+	select {
+	case <-d.stopCh:
+		return nil
+	default:
+		close(d.stopCh)
+	}
+
+	d.lock.Lock()
+	cmd := d.cmd
+	d.cmd = nil
+	d.connected = false
+	d.lock.Unlock()
+	stopProcess(cmd)
+	return nil
+}
+
+func (d *PluginDriver) call(ctx context.Context, method string, args, reply interface{}) error {
+	d.lock.Lock()
+	connected := d.connected
+	d.lock.Unlock()
+	if !connected {
+		return driver.Errorf(driver.ErrorTransient, "plugin %s isn't connected", d.socket)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", d.socket)
+	if err != nil {
+		return driver.Errorf(driver.ErrorTransient, "connect plugin %s failed %s", d.socket, err.Error())
+	}
+	cli := jsonrpc.NewClient(conn)
+	defer cli.Close()
+
+	call := cli.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return driver.Errorf(driver.ErrorTransient, "call plugin %s %s failed %s", d.socket, method, ctx.Err().Error())
+	case <-call.Done:
+		if call.Error != nil {
+			err := decodeError(call.Error)
+			return driver.Errorf(driver.ErrorTypeOf(err), "call plugin %s %s failed %s", d.socket, method, err.Error())
+		}
+		return nil
+	}
+}
+
+func (d *PluginDriver) healthCheckLoop() {
+	ticker := time.NewTicker(d.healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		err := d.healthCheck()
+		if err == nil {
+			continue
+		}
+		log.Warnf("[PluginDriver] plugin %s health check failed %s, will restart", d.socket, err.Error())
+		d.restart()
+	}
+}
+
+func (d *PluginDriver) healthCheck() error {
+	ctx, cancel := context.WithTimeout(context.Background(), d.healthInterval)
+	defer cancel()
+	resp := &HealthResponse{}
+	if err := d.call(ctx, healthMethod, HealthRequest{}, resp); err != nil {
+		return err
+	}
+	if !resp.Healthy {
+		return fmt.Errorf("plugin reports unhealthy %s", resp.Message)
+	}
+	return nil
+}
+
+// restart starts the plugin without lock held, since it may take startTimeout,
+// calls fail as not connected during restarting
+func (d *PluginDriver) restart() {
+	d.lock.Lock()
+	old := d.cmd
+	d.cmd = nil
+	d.connected = false
+	d.lock.Unlock()
+	stopProcess(old)
+
+	cmd, info, err := d.start()
+	if err != nil {
+		log.Warnf("[PluginDriver] restart plugin %s failed %s", d.socket, err.Error())
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	select {
+	case <-d.stopCh:
+		// closed during restarting
+		stopProcess(cmd)
+		return
+	default:
+	}
+	d.cmd = cmd
+	d.info = info
+	d.connected = true
+}
+
+// start starts the plugin process if path is set and handshakes with it, the
+// started process is stopped if handshake failed
+func (d *PluginDriver) start() (*exec.Cmd, HandshakeResponse, error) {
+	var cmd *exec.Cmd
+	if d.path != "" {
+		// remove stale socket so that we won't dial to it before the new plugin process listens
+		if err := removeStaleSocket(d.socket); err != nil {
+			return nil, HandshakeResponse{}, err
+		}
+		cmd = exec.Command(d.path, d.args...)
+		cmd.Env = append(os.Environ(), SocketEnv+"="+d.socket)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return nil, HandshakeResponse{}, fmt.Errorf("start plugin %s failed %s", d.path, err.Error())
+		}
+	}
+
+	info, err := d.handshake()
+	if err != nil {
+		stopProcess(cmd)
+		return nil, HandshakeResponse{}, err
+	}
+	log.Infof("[PluginDriver] plugin %s %s connected with protocol v%d", info.Name, info.Version, info.ProtocolVersion)
+	return cmd, info, nil
+}
+
+func (d *PluginDriver) handshake() (HandshakeResponse, error) {
+	info := HandshakeResponse{}
+	conn, err := dial(d.socket, startTimeout)
+	if err != nil {
+		return info, err
+	}
+	cli := jsonrpc.NewClient(conn)
+	defer cli.Close()
+
+	if err := cli.Call(handshakeMethod, HandshakeRequest{ProtocolVersions: supportedProtocolVersions}, &info); err != nil {
+		return info, fmt.Errorf("handshake with plugin %s failed %s", d.socket, err.Error())
+	}
+	if negotiateVersion([]int{info.ProtocolVersion}, supportedProtocolVersions) == 0 {
+		return info, fmt.Errorf("plugin %s chose unsupported protocol version %d", d.socket, info.ProtocolVersion)
+	}
+	return info, nil
+}
+
+func stopProcess(cmd *exec.Cmd) {
+	if cmd != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+}
+
+func dial(socket string, timeout time.Duration) (net.Conn, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("connect plugin %s failed %s", socket, err.Error())
+		}
+		time.Sleep(dialRetryInterval)
+	}
+}
+
+var _ driver.Driver = &PluginDriver{}
@@ -0,0 +1,228 @@
+package plugin
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zdnscloud/cement/log"
+	"github.com/zdnscloud/elb-controller/driver"
+	"github.com/zdnscloud/elb-controller/driver/drivertest"
+	"github.com/zdnscloud/elb-controller/driver/testdriver"
+)
+
+func TestMain(m *testing.M) {
+	log.InitLogger(log.Warn)
+	os.Exit(m.Run())
+}
+
+// testSocket returns socket path in a temp dir and the function removing the dir
+func testSocket(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "elbc-plugin")
+	if err != nil {
+		t.Fatalf("create temp dir failed %s", err.Error())
+	}
+	return filepath.Join(dir, "plugin.sock"), func() { os.RemoveAll(dir) }
+}
+
+// serve is Serve with a listener which can be closed by the test
+func serve(t *testing.T, socket string, rcvr interface{}) net.Listener {
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("listen %s failed %s", socket, err.Error())
+	}
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(ServiceName, rcvr); err != nil {
+		t.Fatalf("register plugin failed %s", err.Error())
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+		}
+	}()
+	return l
+}
+
+// newTestPlugin returns plugin driver connected to d and the function stopping them
+func newTestPlugin(t *testing.T, d driver.Driver) (*PluginDriver, func()) {
+	socket, clean := testSocket(t)
+	l := serve(t, socket, &server{name: "test", driver: d})
+	p, err := New(socket, "", nil, time.Hour)
+	if err != nil {
+		l.Close()
+		clean()
+		t.Fatalf("new plugin driver failed %s", err.Error())
+	}
+	return p, func() {
+		p.Close()
+		l.Close()
+		clean()
+	}
+}
+
+func testConfig() driver.Config {
+	return driver.Config{
+		K8sCluster:   "cluster",
+		K8sNamespace: "default",
+		K8sService:   "web",
+		VIP:          "192.0.2.10",
+		Method:       driver.LBMethodRoundRobin,
+		Services: []driver.Service{
+			{
+				Port:         80,
+				BackendPort:  30080,
+				BackendHosts: []string{"198.51.100.1"},
+				Protocol:     driver.ProtocolTCP,
+			},
+		},
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	d := testdriver.New()
+	p, stop := newTestPlugin(t, d)
+	defer stop()
+	if !reflect.DeepEqual(p.Capabilities(), d.Capabilities()) {
+		t.Fatalf("capabilities should be the plugin ones but got %v", p.Capabilities())
+	}
+	if v := p.Version(); !strings.Contains(v, d.Version()) || !strings.Contains(v, "protocol v1") {
+		t.Fatalf("version should contain plugin version and protocol but got %s", v)
+	}
+}
+
+type unsupportedVersionPlugin struct{}
+
+func (unsupportedVersionPlugin) Handshake(req HandshakeRequest, resp *HandshakeResponse) error {
+	resp.ProtocolVersion = ProtocolVersion + 1
+	return nil
+}
+
+func TestVersionMismatch(t *testing.T) {
+	if v := negotiateVersion([]int{ProtocolVersion + 1}, supportedProtocolVersions); v != 0 {
+		t.Fatalf("no common version should be 0 but got %v", v)
+	}
+	if v := negotiateVersion([]int{1, 2, 3}, []int{1, 2}); v != 2 {
+		t.Fatalf("highest common version should be 2 but got %v", v)
+	}
+
+	resp := HandshakeResponse{}
+	s := &server{name: "test", driver: testdriver.New()}
+	if err := s.Handshake(HandshakeRequest{ProtocolVersions: []int{ProtocolVersion + 1}}, &resp); err == nil {
+		t.Fatal("plugin should reject controller without common version")
+	}
+
+	socket, clean := testSocket(t)
+	defer clean()
+	l := serve(t, socket, unsupportedVersionPlugin{})
+	defer l.Close()
+	if _, err := New(socket, "", nil, time.Hour); err == nil || !strings.Contains(err.Error(), "unsupported protocol version") {
+		t.Fatalf("plugin choosing unsupported version should be rejected but got %v", err)
+	}
+}
+
+func TestErrorType(t *testing.T) {
+	d := testdriver.New()
+	p, stop := newTestPlugin(t, d)
+	defer stop()
+	ctx := context.Background()
+
+	for _, typ := range []driver.ErrorType{driver.ErrorConflict, driver.ErrorAuthFailure, driver.ErrorInvalidConfig} {
+		d.InjectFailure(testdriver.Failure{Action: testdriver.ActionCreate, Type: typ, Message: "injected", Times: 1})
+		err := p.Create(ctx, testConfig())
+		if driver.ErrorTypeOf(err) != typ || !strings.Contains(err.Error(), "injected") {
+			t.Fatalf("create should fail with %s but got %v", typ, err)
+		}
+	}
+	if err := p.Delete(ctx, testConfig()); driver.ErrorTypeOf(err) != driver.ErrorNotFound {
+		t.Fatalf("delete missing config should fail with not found but got %v", err)
+	}
+	if err := p.Create(ctx, testConfig()); err != nil {
+		t.Fatalf("create should succeed but got %s", err.Error())
+	}
+	if _, ok := d.Get("cluster", "default", "web"); !ok {
+		t.Fatal("config should be programmed through plugin")
+	}
+}
+
+// blockingDriver blocks create until the call is canceled
+type blockingDriver struct {
+	*testdriver.TestDriver
+}
+
+func (d blockingDriver) Create(ctx context.Context, c driver.Config) error {
+	<-ctx.Done()
+	return driver.NewError(driver.ErrorTransient, ctx.Err())
+}
+
+func TestCallCanceled(t *testing.T) {
+	p, stop := newTestPlugin(t, blockingDriver{testdriver.New()})
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := p.Create(ctx, testConfig()); driver.ErrorTypeOf(err) != driver.ErrorTransient {
+		t.Fatalf("canceled call should fail with transient error but got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("canceled call should return when its context is done")
+	}
+	// other calls aren't affected by the abandoned one
+	if _, err := p.Inventory(context.Background(), "cluster"); err != nil {
+		t.Fatalf("inventory should succeed but got %s", err.Error())
+	}
+}
+
+func TestHealthCheckRestart(t *testing.T) {
+	d := testdriver.New()
+	socket, clean := testSocket(t)
+	defer clean()
+	l := serve(t, socket, &server{name: "test", driver: d})
+	p, err := New(socket, "", nil, 50*time.Millisecond)
+	if err != nil {
+		t.Fatalf("new plugin driver failed %s", err.Error())
+	}
+	defer p.Close()
+
+	l.Close()
+	waitFor(t, func() bool {
+		err := p.Create(context.Background(), testConfig())
+		return err != nil && strings.Contains(err.Error(), "isn't connected")
+	})
+	if driver.ErrorTypeOf(p.Create(context.Background(), testConfig())) != driver.ErrorTransient {
+		t.Fatal("call during restarting should fail with transient error")
+	}
+
+	l = serve(t, socket, &server{name: "test", driver: d})
+	defer l.Close()
+	waitFor(t, func() bool {
+		return p.Create(context.Background(), testConfig()) == nil
+	})
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition isn't met before timeout")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	p, stop := newTestPlugin(t, testdriver.New())
+	defer stop()
+	drivertest.Run(t, p, drivertest.InventoryInspector(p))
+}
@@ -0,0 +1,160 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zdnscloud/elb-controller/driver"
+)
+
+// plugin protocol is json-rpc 1.0 over a unix socket, every call of driver.Driver
+// is forwarded to the method with the same name of rpc service "Plugin"
+const (
+	ServiceName     = "Plugin"
+	ProtocolVersion = 1
+
+	// SocketEnv is set to the socket path the plugin process should listen on
+	// when the plugin process is started by the controller
+	SocketEnv = "ELBC_PLUGIN_SOCKET"
+
+	handshakeMethod = ServiceName + ".Handshake"
+	healthMethod    = ServiceName + ".Health"
+	createMethod    = ServiceName + ".Create"
+	updateMethod    = ServiceName + ".Update"
+	deleteMethod    = ServiceName + ".Delete"
+	inventoryMethod = ServiceName + ".Inventory"
+	planMethod      = ServiceName + ".Plan"
+)
+
+var supportedProtocolVersions = []int{ProtocolVersion}
+
+type HandshakeRequest struct {
+	ProtocolVersions []int `json:"protocolVersions"`
+}
+
+type HandshakeResponse struct {
+	ProtocolVersion int                 `json:"protocolVersion"`
+	Name            string              `json:"name"`
+	Version         string              `json:"version"`
+	Capabilities    driver.Capabilities `json:"capabilities"`
+}
+
+type HealthRequest struct{}
+
+type HealthResponse struct {
+	Healthy bool   `json:"healthy"`
+	Message string `json:"message"`
+}
+
+// Config fields hold the json produced by driver.Config.ToJson
+type ConfigRequest struct {
+	Deadline time.Time `json:"deadline"`
+	Config   string    `json:"config"`
+}
+
+type UpdateRequest struct {
+	Deadline  time.Time `json:"deadline"`
+	OldConfig string    `json:"oldConfig"`
+	NewConfig string    `json:"newConfig"`
+}
+
+type InventoryRequest struct {
+	Deadline   time.Time `json:"deadline"`
+	K8sCluster string    `json:"k8sCluster"`
+}
+
+type InventoryResponse struct {
+	Configs []driver.Config `json:"configs"`
+}
+
+// PlanRequest empty config means nil
+type PlanRequest struct {
+	Deadline  time.Time `json:"deadline"`
+	OldConfig string    `json:"oldConfig,omitempty"`
+	NewConfig string    `json:"newConfig,omitempty"`
+}
+
+type PlanResponse struct {
+	Operations []driver.Operation `json:"operations"`
+}
+
+type Empty struct{}
+
+func getDeadline(ctx context.Context) time.Time {
+	deadline, _ := ctx.Deadline()
+	return deadline
+}
+
+func withDeadline(deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), deadline)
+}
+
+func parseConfig(s string) (driver.Config, error) {
+	var c driver.Config
+	err := json.Unmarshal([]byte(s), &c)
+	return c, err
+}
+
+func configToJson(c *driver.Config) string {
+	if c == nil {
+		return ""
+	}
+	return c.ToJson()
+}
+
+func parseOptionalConfig(s string) (*driver.Config, error) {
+	if s == "" {
+		return nil, nil
+	}
+	c, err := parseConfig(s)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// driver error type is carried as "[type] message" in json-rpc error string
+func encodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("[%s] %s", driver.ErrorTypeOf(err), err.Error())
+}
+
+func decodeError(err error) error {
+	var serverErr rpc.ServerError
+	if !errors.As(err, &serverErr) {
+		return driver.NewError(driver.ErrorTransient, err)
+	}
+	msg := string(serverErr)
+	if strings.HasPrefix(msg, "[") {
+		if i := strings.Index(msg, "] "); i > 0 {
+			return driver.NewError(driver.ErrorType(msg[1:i]), errors.New(msg[i+2:]))
+		}
+	}
+	return driver.NewError(driver.ErrorUnknown, errors.New(msg))
+}
+
+// removeStaleSocket removes socket left by previous plugin process, other files
+// on the path are kept and reported since the path may be set by mistake
+func removeStaleSocket(socket string) error {
+	fi, err := os.Lstat(socket)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("plugin socket %s exists and isn't a socket", socket)
+	}
+	return os.Remove(socket)
+}
@@ -0,0 +1,136 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/zdnscloud/elb-controller/driver"
+)
+
+type server struct {
+	name   string
+	driver driver.Driver
+}
+
+// Serve is used by plugin written in go, it exposes d on the unix socket until listener failed
+func Serve(socket, name string, d driver.Driver) error {
+	if err := removeStaleSocket(socket); err != nil {
+		return err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(ServiceName, &server{name: name, driver: d}); err != nil {
+		return err
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+func (s *server) Handshake(req HandshakeRequest, resp *HandshakeResponse) error {
+	version := negotiateVersion(req.ProtocolVersions, supportedProtocolVersions)
+	if version == 0 {
+		return fmt.Errorf("no common protocol version, plugin supports %v, controller supports %v", supportedProtocolVersions, req.ProtocolVersions)
+	}
+	*resp = HandshakeResponse{
+		ProtocolVersion: version,
+		Name:            s.name,
+		Version:         s.driver.Version(),
+		Capabilities:    s.driver.Capabilities(),
+	}
+	return nil
+}
+
+func (s *server) Health(req HealthRequest, resp *HealthResponse) error {
+	resp.Healthy = true
+	return nil
+}
+
+func (s *server) Create(req ConfigRequest, resp *Empty) error {
+	c, err := parseConfig(req.Config)
+	if err != nil {
+		return encodeError(driver.NewError(driver.ErrorInvalidConfig, err))
+	}
+	ctx, cancel := withDeadline(req.Deadline)
+	defer cancel()
+	return encodeError(s.driver.Create(ctx, c))
+}
+
+func (s *server) Update(req UpdateRequest, resp *Empty) error {
+	old, err := parseConfig(req.OldConfig)
+	if err != nil {
+		return encodeError(driver.NewError(driver.ErrorInvalidConfig, err))
+	}
+	new, err := parseConfig(req.NewConfig)
+	if err != nil {
+		return encodeError(driver.NewError(driver.ErrorInvalidConfig, err))
+	}
+	ctx, cancel := withDeadline(req.Deadline)
+	defer cancel()
+	return encodeError(s.driver.Update(ctx, old, new))
+}
+
+func (s *server) Delete(req ConfigRequest, resp *Empty) error {
+	c, err := parseConfig(req.Config)
+	if err != nil {
+		return encodeError(driver.NewError(driver.ErrorInvalidConfig, err))
+	}
+	ctx, cancel := withDeadline(req.Deadline)
+	defer cancel()
+	return encodeError(s.driver.Delete(ctx, c))
+}
+
+func (s *server) Inventory(req InventoryRequest, resp *InventoryResponse) error {
+	ctx, cancel := withDeadline(req.Deadline)
+	defer cancel()
+	configs, err := s.driver.Inventory(ctx, req.K8sCluster)
+	if err != nil {
+		return encodeError(err)
+	}
+	resp.Configs = configs
+	return nil
+}
+
+func (s *server) Plan(req PlanRequest, resp *PlanResponse) error {
+	old, err := parseOptionalConfig(req.OldConfig)
+	if err != nil {
+		return encodeError(driver.NewError(driver.ErrorInvalidConfig, err))
+	}
+	new, err := parseOptionalConfig(req.NewConfig)
+	if err != nil {
+		return encodeError(driver.NewError(driver.ErrorInvalidConfig, err))
+	}
+	ctx, cancel := withDeadline(req.Deadline)
+	defer cancel()
+	ops, err := s.driver.Plan(ctx, old, new)
+	if err != nil {
+		return encodeError(err)
+	}
+	resp.Operations = ops
+	return nil
+}
+
+// negotiateVersion returns the highest version both sides support, 0 means none
+func negotiateVersion(theirs, ours []int) int {
+	result := 0
+	for _, t := range theirs {
+		for _, o := range ours {
+			if t == o && t > result {
+				result = t
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"sync"
+
+	"github.com/zdnscloud/elb-controller/driver/radware/types"
+)
+
+const (
+	certificatePath       = "/config/SlbNewSslCfgCertsSrvrCertTable/"
+	certificateKeyPath    = "/config/SlbNewSslCfgCertsKeyTable/"
+	certificateImportPath = "/config/sslcertimport"
+
+	certificateImportTypeKey  = "key"
+	certificateImportTypeCert = "srvrcert"
+)
+
+// CertificateClient remembers the digest of certificates it imported, since the
+// certificate content isn't readable from the device
+type CertificateClient struct {
+	token    string
+	server   string
+	lock     sync.Mutex
+	imported map[string]string
+}
+
+func NewCertificateClient(token, serverAddr string) *CertificateClient {
+	return &CertificateClient{
+		token:    token,
+		server:   serverAddr,
+		imported: make(map[string]string),
+	}
+}
+
+// Import imports pem encoded private key and server certificate with id, the
+// existing ones with the same id are overwritten, so it also rotates certificate;
+// the same certificate imported before is skipped if it still exists
+func (c *CertificateClient) Import(ctx context.Context, id, cert, key string) error {
+	digest := genCertificateDigest(cert, key)
+	c.lock.Lock()
+	imported := c.imported[id] == digest
+	c.lock.Unlock()
+	if imported {
+		_, err := c.Get(ctx, id)
+		if err == nil {
+			return nil
+		} else if err != ResourceNotFoundError {
+			return err
+		}
+	}
+
+	c.forget(id)
+	if err := importText(ctx, c.genImportUrl(id, certificateImportTypeKey), c.token, key); err != nil {
+		return err
+	}
+	if err := importText(ctx, c.genImportUrl(id, certificateImportTypeCert), c.token, cert); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.imported[id] = digest
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *CertificateClient) forget(id string) {
+	// builtin delete is shadowed by the request helper of this package
+	c.lock.Lock()
+	c.imported[id] = ""
+	c.lock.Unlock()
+}
+
+func genCertificateDigest(cert, key string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(cert+key)))
+}
+
+func (c *CertificateClient) Delete(ctx context.Context, id string) error {
+	c.forget(id)
+	_, err := c.Get(ctx, id)
+	if err != nil {
+		if err == ResourceNotFoundError {
+			return nil
+		}
+		return err
+	}
+	if err := delete(ctx, c.genUrl(certificatePath, id), c.token); err != nil {
+		return err
+	}
+	return delete(ctx, c.genUrl(certificateKeyPath, id), c.token)
+}
+
+func (c *CertificateClient) Get(ctx context.Context, id string) (*types.Certificate, error) {
+	list := &types.CertificateList{}
+	if err := get(ctx, c.genUrl(certificatePath, id), c.token, list); err != nil {
+		return nil, err
+	}
+	if len(list.CertTable) == 0 {
+		return nil, ResourceNotFoundError
+	}
+	return &list.CertTable[0], nil
+}
+
+func (c *CertificateClient) genUrl(path, id string) string {
+	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, path, id)
+}
+
+func (c *CertificateClient) genImportUrl(id, typ string) string {
+	return fmt.Sprintf("%s%s%s?id=%s&type=%s&src=txt", reqUrlPrefix, c.server, certificateImportPath, url.QueryEscape(id), typ)
+}
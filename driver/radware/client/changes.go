@@ -0,0 +1,30 @@
+package client
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+type changesKey struct{}
+
+// Changes records whether any object is created, updated or deleted by the
+// requests sent with its context, so unchanged configs needn't be applied
+type Changes struct {
+	changed int32
+}
+
+func WithChanges(ctx context.Context) (context.Context, *Changes) {
+	changes := &Changes{}
+	return context.WithValue(ctx, changesKey{}, changes), changes
+}
+
+func (c *Changes) Changed() bool {
+	return atomic.LoadInt32(&c.changed) == 1
+}
+
+// recordChange is called before the request is sent, since a failed request may change the device too
+func recordChange(ctx context.Context) {
+	if changes, ok := ctx.Value(changesKey{}).(*Changes); ok {
+		atomic.StoreInt32(&changes.changed, 1)
+	}
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 
@@ -27,6 +28,10 @@ type Client struct {
 	serverGroup    *ServerGroupClient
 	virtualServer  *VirtualServerClient
 	virtualService *VirtualServiceClient
+	healthCheck    *HealthCheckClient
+	sslPolicy      *SSLPolicyClient
+	certificate    *CertificateClient
+	networkClass   *NetworkClassClient
 }
 
 func New(user, password, serverAddr string) *Client {
@@ -39,6 +44,10 @@ func New(user, password, serverAddr string) *Client {
 		serverGroup:    NewServerGroupClient(token, serverAddr),
 		virtualServer:  NewVirtualServerClient(token, serverAddr),
 		virtualService: NewVirtualServiceClient(token, serverAddr),
+		healthCheck:    NewHealthCheckClient(token, serverAddr),
+		sslPolicy:      NewSSLPolicyClient(token, serverAddr),
+		certificate:    NewCertificateClient(token, serverAddr),
+		networkClass:   NewNetworkClassClient(token, serverAddr),
 	}
 }
 
@@ -67,44 +76,60 @@ func (c *Client) VirtualService() *VirtualServiceClient {
 	return c.virtualService
 }
 
-func (c *Client) ApplyAndSave() error {
-	if err := c.Apply(); err != nil {
+func (c *Client) HealthCheck() *HealthCheckClient {
+	return c.healthCheck
+}
+
+func (c *Client) SSLPolicy() *SSLPolicyClient {
+	return c.sslPolicy
+}
+
+func (c *Client) Certificate() *CertificateClient {
+	return c.certificate
+}
+
+func (c *Client) NetworkClass() *NetworkClassClient {
+	return c.networkClass
+}
+
+func (c *Client) ApplyAndSave(ctx context.Context) error {
+	if err := c.Apply(ctx); err != nil {
 		return err
 	}
-	return c.Save()
+	return c.Save(ctx)
 }
 
-func (c *Client) Apply() error {
+func (c *Client) Apply(ctx context.Context) error {
 	url := fmt.Sprintf("%s%s%s", reqUrlPrefix, c.server, applyActionPath)
-	return actionWithRetry(url, c.token)
+	return actionWithRetry(ctx, url, c.token)
 }
 
-func (c *Client) Save() error {
+func (c *Client) Save(ctx context.Context) error {
 	url := fmt.Sprintf("%s%s%s", reqUrlPrefix, c.server, saveActionPath)
-	return actionWithRetry(url, c.token)
+	return actionWithRetry(ctx, url, c.token)
 }
 
-func (c *Client) RollBack() error {
-	if err := c.revert(); err != nil {
+func (c *Client) RollBack(ctx context.Context) error {
+	if err := c.revert(ctx); err != nil {
 		return err
 	}
-	return c.revertApply()
+	return c.revertApply(ctx)
 }
 
-func (c *Client) revert() error {
+func (c *Client) revert(ctx context.Context) error {
 	url := fmt.Sprintf("%s%s%s", reqUrlPrefix, c.server, revertActionPath)
-	return actionWithRetry(url, c.token)
+	return actionWithRetry(ctx, url, c.token)
 }
 
-func (c *Client) revertApply() error {
+func (c *Client) revertApply(ctx context.Context) error {
 	url := fmt.Sprintf("%s%s%s", reqUrlPrefix, c.server, revertApplyActionPath)
-	return actionWithRetry(url, c.token)
+	return actionWithRetry(ctx, url, c.token)
 }
 
-func (c *Client) IsMaster() (bool, error) {
+func (c *Client) IsMaster(ctx context.Context) (bool, error) {
 	s := &types.HaState{}
 	url := fmt.Sprintf("%s%s%s", reqUrlPrefix, c.server, haStatePath)
-	if err := get(url, c.token, s); err != nil {
+	if err := get(ctx, url, c.token, s); err != nil {
 		return false, err
 	}
 	if s.HaSwitchInfoState == types.HaSwitchInfoStateMaster {
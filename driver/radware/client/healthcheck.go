@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zdnscloud/elb-controller/driver/radware/types"
+)
+
+const (
+	healthCheckPathPrefix = "/config/"
+)
+
+var healthCheckTables = map[types.HealthCheckKind]string{
+	types.HealthCheckKindTcp:  "SlbNewAdvhcTcpTable",
+	types.HealthCheckKindUdp:  "SlbNewAdvhcUdpTable",
+	types.HealthCheckKindHttp: "SlbNewAdvhcHttpTable",
+	types.HealthCheckKindIcmp: "SlbNewAdvhcIcmpTable",
+}
+
+type HealthCheckClient struct {
+	token  string
+	server string
+}
+
+func NewHealthCheckClient(token, serverAddr string) *HealthCheckClient {
+	return &HealthCheckClient{
+		token:  token,
+		server: serverAddr,
+	}
+}
+
+func (c *HealthCheckClient) Reconcile(ctx context.Context, kind types.HealthCheckKind, id string, hc *types.HealthCheck) error {
+	exist, err := c.Get(ctx, kind, id)
+	if err != nil {
+		if err == ResourceNotFoundError {
+			return c.create(ctx, kind, id, hc)
+		}
+		return err
+	}
+	if isHealthCheckEqual(exist, hc) {
+		return nil
+	}
+	return c.update(ctx, kind, id, hc)
+}
+
+func (c *HealthCheckClient) create(ctx context.Context, kind types.HealthCheckKind, id string, hc *types.HealthCheck) error {
+	return create(ctx, c.genUrl(kind, id), c.token, hc)
+}
+
+func (c *HealthCheckClient) update(ctx context.Context, kind types.HealthCheckKind, id string, hc *types.HealthCheck) error {
+	return update(ctx, c.genUrl(kind, id), c.token, hc)
+}
+
+func (c *HealthCheckClient) Delete(ctx context.Context, kind types.HealthCheckKind, id string) error {
+	_, err := c.Get(ctx, kind, id)
+	if err != nil {
+		if err == ResourceNotFoundError {
+			return nil
+		}
+		return err
+	}
+	return delete(ctx, c.genUrl(kind, id), c.token)
+}
+
+func (c *HealthCheckClient) Get(ctx context.Context, kind types.HealthCheckKind, id string) (*types.HealthCheck, error) {
+	list := map[string][]types.HealthCheck{}
+	if err := get(ctx, c.genUrl(kind, id), c.token, &list); err != nil {
+		return nil, err
+	}
+	hcs := list[healthCheckTables[kind]]
+	if len(hcs) == 0 {
+		return nil, ResourceNotFoundError
+	}
+	return &hcs[0], nil
+}
+
+func isHealthCheckEqual(h1, h2 *types.HealthCheck) bool {
+	if h1 == nil || h2 == nil {
+		return false
+	}
+	return *h1 == *h2
+}
+
+func (c *HealthCheckClient) genUrl(kind types.HealthCheckKind, id string) string {
+	return fmt.Sprintf("%s%s%s%s/%s", reqUrlPrefix, c.server, healthCheckPathPrefix, healthCheckTables[kind], id)
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zdnscloud/elb-controller/driver/radware/types"
+)
+
+const (
+	networkClassPath   = "/config/SlbNewCfgNetworkClassTable/"
+	networkElementPath = "/config/SlbNewCfgNwclssNetworkElemTable/"
+)
+
+type NetworkClassClient struct {
+	token  string
+	server string
+}
+
+func NewNetworkClassClient(token, serverAddr string) *NetworkClassClient {
+	return &NetworkClassClient{
+		token:  token,
+		server: serverAddr,
+	}
+}
+
+// Reconcile makes the elements of network class id exactly the same as elements, which is keyed by element id
+func (c *NetworkClassClient) Reconcile(ctx context.Context, id string, elements map[string]*types.NetworkElement) error {
+	if _, err := c.get(ctx, id); err != nil {
+		if err != ResourceNotFoundError {
+			return err
+		}
+		if err := create(ctx, c.genUrl(id), c.token, &types.NetworkClass{Name: id}); err != nil {
+			return err
+		}
+	}
+
+	exists, err := c.GetElements(ctx, id)
+	if err != nil {
+		return err
+	}
+	for elemID, exist := range exists {
+		if _, ok := elements[elemID]; !ok {
+			if err := delete(ctx, c.genElementUrl(id, elemID), c.token); err != nil {
+				return err
+			}
+			continue
+		}
+		if !isNetworkElementEqual(exist, elements[elemID]) {
+			if err := update(ctx, c.genElementUrl(id, elemID), c.token, elements[elemID]); err != nil {
+				return err
+			}
+		}
+	}
+	for elemID, e := range elements {
+		if _, ok := exists[elemID]; !ok {
+			if err := create(ctx, c.genElementUrl(id, elemID), c.token, e); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *NetworkClassClient) Delete(ctx context.Context, id string) error {
+	_, err := c.get(ctx, id)
+	if err != nil {
+		if err == ResourceNotFoundError {
+			return nil
+		}
+		return err
+	}
+	return delete(ctx, c.genUrl(id), c.token)
+}
+
+func (c *NetworkClassClient) get(ctx context.Context, id string) (*types.NetworkClass, error) {
+	list := &types.NetworkClassList{}
+	if err := get(ctx, c.genUrl(id), c.token, list); err != nil {
+		return nil, err
+	}
+	if len(list.NCTable) == 0 {
+		return nil, ResourceNotFoundError
+	}
+	return &list.NCTable[0], nil
+}
+
+// GetElements returns the elements of network class id keyed by element id
+func (c *NetworkClassClient) GetElements(ctx context.Context, id string) (map[string]*types.NetworkElement, error) {
+	list := &types.NetworkElementList{}
+	if err := get(ctx, c.genElementListUrl(id), c.token, list); err != nil {
+		return nil, err
+	}
+	result := make(map[string]*types.NetworkElement)
+	for i := range list.NETable {
+		result[list.NETable[i].NetElemIndex] = &list.NETable[i]
+	}
+	return result, nil
+}
+
+func isNetworkElementEqual(e1, e2 *types.NetworkElement) bool {
+	if e1 == nil || e2 == nil {
+		return false
+	}
+	if e2.IpVer == types.IpVer6 {
+		return e1.IpVer == e2.IpVer && isIPEqual(e1.Net, e2.Net) && e1.Prefix == e2.Prefix && e1.NetType == e2.NetType && e1.MatchType == e2.MatchType
+	}
+	return e1.Net == e2.Net && e1.Mask == e2.Mask && e1.NetType == e2.NetType && e1.MatchType == e2.MatchType
+}
+
+func (c *NetworkClassClient) genUrl(id string) string {
+	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, networkClassPath, id)
+}
+
+func (c *NetworkClassClient) genElementListUrl(id string) string {
+	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, networkElementPath, id)
+}
+
+func (c *NetworkClassClient) genElementUrl(id, elemID string) string {
+	return fmt.Sprintf("%s%s%s%s/%s", reqUrlPrefix, c.server, networkElementPath, id, elemID)
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/zdnscloud/elb-controller/driver/radware/types"
@@ -22,42 +23,42 @@ func NewRealServerClient(token, serverAddr string) *RealServerClient {
 	}
 }
 
-func (c *RealServerClient) Reconcile(id string, rs *types.RealServer) error {
-	exist, err := c.get(id)
+func (c *RealServerClient) Reconcile(ctx context.Context, id string, rs *types.RealServer) error {
+	exist, err := c.Get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
-			return c.create(id, rs)
+			return c.create(ctx, id, rs)
 		}
 		return err
 	}
 	if isRealServerEqual(exist, rs) {
 		return nil
 	}
-	return c.update(id, rs)
+	return c.update(ctx, id, rs)
 }
 
-func (c *RealServerClient) create(id string, rs *types.RealServer) error {
-	return create(c.genUrl(id), c.token, rs)
+func (c *RealServerClient) create(ctx context.Context, id string, rs *types.RealServer) error {
+	return create(ctx, c.genUrl(id), c.token, rs)
 }
 
-func (c *RealServerClient) update(id string, rs *types.RealServer) error {
-	return update(c.genUrl(id), c.token, rs)
+func (c *RealServerClient) update(ctx context.Context, id string, rs *types.RealServer) error {
+	return update(ctx, c.genUrl(id), c.token, rs)
 }
 
-func (c *RealServerClient) Delete(id string) error {
-	_, err := c.get(id)
+func (c *RealServerClient) Delete(ctx context.Context, id string) error {
+	_, err := c.Get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
 			return nil
 		}
 		return err
 	}
-	return delete(c.genUrl(id), c.token)
+	return delete(ctx, c.genUrl(id), c.token)
 }
 
-func (c *RealServerClient) get(id string) (*types.RealServer, error) {
+func (c *RealServerClient) Get(ctx context.Context, id string) (*types.RealServer, error) {
 	rss := &types.RealServerList{}
-	if err := get(c.genUrl(id), c.token, rss); err != nil {
+	if err := get(ctx, c.genUrl(id), c.token, rss); err != nil {
 		return nil, err
 	}
 	if len(rss.RSTable) == 0 {
@@ -70,6 +71,12 @@ func isRealServerEqual(rs1, rs2 *types.RealServer) bool {
 	if rs1 == nil || rs2 == nil {
 		return false
 	}
+	if rs1.Weight != rs2.Weight {
+		return false
+	}
+	if rs2.IpVer == types.IpVer6 {
+		return rs1.IpVer == rs2.IpVer && isIPEqual(rs1.Ipv6Addr, rs2.Ipv6Addr) && rs1.State == rs2.State && rs1.Type == rs2.Type
+	}
 	return rs1.IpAddr == rs2.IpAddr && rs1.State == rs2.State && rs1.Type == rs2.Type
 }
 
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/zdnscloud/elb-controller/driver/radware/types"
@@ -22,42 +23,42 @@ func NewRealServerPortClient(token, serverAddr string) *RealServerPortClient {
 	}
 }
 
-func (c *RealServerPortClient) Reconcile(id string, p *types.RealServerPort) error {
-	exist, err := c.get(id)
+func (c *RealServerPortClient) Reconcile(ctx context.Context, id string, p *types.RealServerPort) error {
+	exist, err := c.get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
-			return c.create(id, p)
+			return c.create(ctx, id, p)
 		}
 		return err
 	}
 	if isRealServerPortEqual(exist, p) {
 		return nil
 	}
-	return c.update(id, p)
+	return c.update(ctx, id, p)
 }
 
-func (c *RealServerPortClient) create(id string, p *types.RealServerPort) error {
-	return create(c.genUrl(id), c.token, p)
+func (c *RealServerPortClient) create(ctx context.Context, id string, p *types.RealServerPort) error {
+	return create(ctx, c.genUrl(id), c.token, p)
 }
 
-func (c *RealServerPortClient) update(id string, p *types.RealServerPort) error {
-	return update(c.genUrl(id), c.token, p)
+func (c *RealServerPortClient) update(ctx context.Context, id string, p *types.RealServerPort) error {
+	return update(ctx, c.genUrl(id), c.token, p)
 }
 
-func (c *RealServerPortClient) Delete(id string) error {
-	_, err := c.get(id)
+func (c *RealServerPortClient) Delete(ctx context.Context, id string) error {
+	_, err := c.get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
 			return nil
 		}
 		return err
 	}
-	return delete(c.genUrl(id), c.token)
+	return delete(ctx, c.genUrl(id), c.token)
 }
 
-func (c *RealServerPortClient) get(id string) (*types.RealServerPort, error) {
+func (c *RealServerPortClient) get(ctx context.Context, id string) (*types.RealServerPort, error) {
 	list := &types.RealServerPortList{}
-	if err := get(c.genUrl(id), c.token, list); err != nil {
+	if err := get(ctx, c.genUrl(id), c.token, list); err != nil {
 		return nil, err
 	}
 	if len(list.RSPortTable) == 0 {
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/zdnscloud/elb-controller/driver/radware/types"
 )
@@ -23,11 +25,11 @@ func NewServerGroupClient(token, serverAddr string) *ServerGroupClient {
 	}
 }
 
-func (c *ServerGroupClient) Reconcile(id string, sg *types.ServerGroup) error {
-	exist, err := c.get(id)
+func (c *ServerGroupClient) Reconcile(ctx context.Context, id string, sg *types.ServerGroup) error {
+	exist, err := c.Get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
-			return c.create(id, sg)
+			return c.create(ctx, id, sg)
 		}
 		return err
 	}
@@ -35,57 +37,57 @@ func (c *ServerGroupClient) Reconcile(id string, sg *types.ServerGroup) error {
 	if isServerGroupEqual(exist, sg) {
 		return nil
 	}
-	return c.update(id, sg)
+	return c.update(ctx, id, sg)
 }
 
-func (c *ServerGroupClient) ReconcileServer(id, rsID string) error {
-	servers, err := c.getGroupServers(id)
+func (c *ServerGroupClient) ReconcileServer(ctx context.Context, id, rsID string) error {
+	servers, err := c.GetServers(ctx, id)
 	if err != nil {
 		return err
 	}
 	if isRealServerInGroup(rsID, servers) {
 		return nil
 	}
-	return c.addServer(id, rsID)
+	return c.addServer(ctx, id, rsID)
 }
 
-func (c *ServerGroupClient) addServer(id, rsID string) error {
-	return c.update(id, types.NewAddServerServerGroup(rsID))
+func (c *ServerGroupClient) addServer(ctx context.Context, id, rsID string) error {
+	return c.update(ctx, id, types.NewAddServerServerGroup(rsID))
 }
 
-func (c *ServerGroupClient) RemoveServer(id, rsID string) error {
-	servers, err := c.getGroupServers(id)
+func (c *ServerGroupClient) RemoveServer(ctx context.Context, id, rsID string) error {
+	servers, err := c.GetServers(ctx, id)
 	if err != nil {
 		return err
 	}
 	if isRealServerInGroup(rsID, servers) {
-		return c.update(id, types.NewRemoveServerServerGroup(rsID))
+		return c.update(ctx, id, types.NewRemoveServerServerGroup(rsID))
 	}
 	return nil
 }
 
-func (c *ServerGroupClient) create(id string, obj *types.ServerGroup) error {
-	return create(c.genUrl(id), c.token, obj)
+func (c *ServerGroupClient) create(ctx context.Context, id string, obj *types.ServerGroup) error {
+	return create(ctx, c.genUrl(id), c.token, obj)
 }
 
-func (c *ServerGroupClient) update(id string, obj *types.ServerGroup) error {
-	return update(c.genUrl(id), c.token, obj)
+func (c *ServerGroupClient) update(ctx context.Context, id string, obj *types.ServerGroup) error {
+	return update(ctx, c.genUrl(id), c.token, obj)
 }
 
-func (c *ServerGroupClient) Delete(id string) error {
-	_, err := c.get(id)
+func (c *ServerGroupClient) Delete(ctx context.Context, id string) error {
+	_, err := c.Get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
 			return nil
 		}
 		return err
 	}
-	return delete(c.genUrl(id), c.token)
+	return delete(ctx, c.genUrl(id), c.token)
 }
 
-func (c *ServerGroupClient) get(id string) (*types.ServerGroup, error) {
+func (c *ServerGroupClient) Get(ctx context.Context, id string) (*types.ServerGroup, error) {
 	list := &types.ServerGroupList{}
-	if err := get(c.genUrl(id), c.token, list); err != nil {
+	if err := get(ctx, c.genUrl(id), c.token, list); err != nil {
 		return nil, err
 	}
 	if len(list.SGTable) == 0 {
@@ -94,6 +96,14 @@ func (c *ServerGroupClient) get(id string) (*types.ServerGroup, error) {
 	return &list.SGTable[0], nil
 }
 
+func (c *ServerGroupClient) List(ctx context.Context) ([]types.ServerGroup, error) {
+	list := &types.ServerGroupList{}
+	if err := get(ctx, c.genListUrl(), c.token, list); err != nil {
+		return nil, err
+	}
+	return list.SGTable, nil
+}
+
 func isRealServerInGroup(rsID string, servers []types.GroupServer) bool {
 	for _, s := range servers {
 		if s.Index == rsID {
@@ -103,9 +113,9 @@ func isRealServerInGroup(rsID string, servers []types.GroupServer) bool {
 	return false
 }
 
-func (c *ServerGroupClient) getGroupServers(id string) ([]types.GroupServer, error) {
+func (c *ServerGroupClient) GetServers(ctx context.Context, id string) ([]types.GroupServer, error) {
 	list := &types.GroupServerList{}
-	if err := get(c.genGroupServerUrl(id), c.token, list); err != nil {
+	if err := get(ctx, c.genGroupServerUrl(id), c.token, list); err != nil {
 		return nil, err
 	}
 	return list.GSTable, nil
@@ -115,6 +125,9 @@ func isServerGroupEqual(s1, s2 *types.ServerGroup) bool {
 	if s1 == nil || s2 == nil {
 		return false
 	}
+	if s2.IpVer == types.IpVer6 && s1.IpVer != s2.IpVer {
+		return false
+	}
 	return s1.Metric == s2.Metric && s1.HealthID == s2.HealthID
 }
 
@@ -122,6 +135,10 @@ func (c *ServerGroupClient) genUrl(id string) string {
 	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, serverGroupPath, id)
 }
 
+func (c *ServerGroupClient) genListUrl() string {
+	return fmt.Sprintf("%s%s%s", reqUrlPrefix, c.server, strings.TrimSuffix(serverGroupPath, "/"))
+}
+
 func (c *ServerGroupClient) genGroupServerUrl(id string) string {
 	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, groupServersPath, id)
 }
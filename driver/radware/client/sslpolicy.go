@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zdnscloud/elb-controller/driver/radware/types"
+)
+
+const (
+	sslPolicyPath = "/config/SlbNewSslCfgSSLPolTable/"
+)
+
+type SSLPolicyClient struct {
+	token  string
+	server string
+}
+
+func NewSSLPolicyClient(token, serverAddr string) *SSLPolicyClient {
+	return &SSLPolicyClient{
+		token:  token,
+		server: serverAddr,
+	}
+}
+
+func (c *SSLPolicyClient) Reconcile(ctx context.Context, id string, p *types.SSLPolicy) error {
+	exist, err := c.Get(ctx, id)
+	if err != nil {
+		if err == ResourceNotFoundError {
+			return create(ctx, c.genUrl(id), c.token, p)
+		}
+		return err
+	}
+	if *exist == *p {
+		return nil
+	}
+	return update(ctx, c.genUrl(id), c.token, p)
+}
+
+func (c *SSLPolicyClient) Delete(ctx context.Context, id string) error {
+	_, err := c.Get(ctx, id)
+	if err != nil {
+		if err == ResourceNotFoundError {
+			return nil
+		}
+		return err
+	}
+	return delete(ctx, c.genUrl(id), c.token)
+}
+
+func (c *SSLPolicyClient) Get(ctx context.Context, id string) (*types.SSLPolicy, error) {
+	list := &types.SSLPolicyList{}
+	if err := get(ctx, c.genUrl(id), c.token, list); err != nil {
+		return nil, err
+	}
+	if len(list.PolicyTable) == 0 {
+		return nil, ResourceNotFoundError
+	}
+	return &list.PolicyTable[0], nil
+}
+
+func (c *SSLPolicyClient) genUrl(id string) string {
+	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, sslPolicyPath, id)
+}
@@ -2,13 +2,17 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"time"
+
+	"github.com/zdnscloud/elb-controller/driver"
 )
 
 const (
@@ -16,12 +20,12 @@ const (
 	failedWaitTime = 5 * time.Second
 )
 
-func get(url, token string, obj interface{}) error {
+func get(ctx context.Context, url, token string, obj interface{}) error {
 	method := http.MethodGet
 
-	resp, err := sendRequest(method, url, token, bytes.NewBuffer([]byte{}))
+	resp, err := sendRequest(ctx, method, url, token, bytes.NewBuffer([]byte{}))
 	if err != nil {
-		return formatError(method, url, err)
+		return driver.NewError(driver.ErrorTransient, formatError(method, url, err))
 	}
 
 	defer resp.Body.Close()
@@ -45,11 +49,12 @@ func get(url, token string, obj interface{}) error {
 			Message string `json:"message"`
 		}{}
 		json.Unmarshal(body, &errInfo)
-		return fmt.Errorf("%s %s failed with status %v : %s", method, url, resp.StatusCode, errInfo.Message)
+		return driver.Errorf(getErrorType(resp.StatusCode), "%s %s failed with status %v : %s", method, url, resp.StatusCode, errInfo.Message)
 	}
 }
 
-func create(url, token string, obj interface{}) error {
+func create(ctx context.Context, url, token string, obj interface{}) error {
+	recordChange(ctx)
 	method := http.MethodPost
 
 	reqBody, err := json.MarshalIndent(obj, "", "  ")
@@ -57,16 +62,17 @@ func create(url, token string, obj interface{}) error {
 		return formatError(method, url, err)
 	}
 
-	resp, err := sendRequest(method, url, token, bytes.NewBuffer(reqBody))
+	resp, err := sendRequest(ctx, method, url, token, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return formatError(method, url, err)
+		return driver.NewError(driver.ErrorTransient, formatError(method, url, err))
 	}
 
 	defer resp.Body.Close()
 	return checkRequestResult(method, url, resp.StatusCode, resp.Body)
 }
 
-func update(url, token string, obj interface{}) error {
+func update(ctx context.Context, url, token string, obj interface{}) error {
+	recordChange(ctx)
 	method := http.MethodPut
 
 	reqBody, err := json.MarshalIndent(obj, "", "  ")
@@ -74,58 +80,81 @@ func update(url, token string, obj interface{}) error {
 		return formatError(method, url, err)
 	}
 
-	resp, err := sendRequest(method, url, token, bytes.NewBuffer(reqBody))
+	resp, err := sendRequest(ctx, method, url, token, bytes.NewBuffer(reqBody))
 	if err != nil {
-		return formatError(method, url, err)
+		return driver.NewError(driver.ErrorTransient, formatError(method, url, err))
 	}
 
 	defer resp.Body.Close()
 	return checkRequestResult(method, url, resp.StatusCode, resp.Body)
 }
 
-func delete(url, token string) error {
+func delete(ctx context.Context, url, token string) error {
+	recordChange(ctx)
 	method := http.MethodDelete
 
-	resp, err := sendRequest(method, url, token, bytes.NewBuffer([]byte{}))
+	resp, err := sendRequest(ctx, method, url, token, bytes.NewBuffer([]byte{}))
 	if err != nil {
-		return formatError(method, url, err)
+		return driver.NewError(driver.ErrorTransient, formatError(method, url, err))
+	}
+
+	defer resp.Body.Close()
+	return checkRequestResult(method, url, resp.StatusCode, resp.Body)
+}
+
+// importText posts plain text body, it's used by certificate import which doesn't accept json
+func importText(ctx context.Context, url, token, text string) error {
+	recordChange(ctx)
+	method := http.MethodPost
+
+	resp, err := sendRequestWithContentType(ctx, method, url, token, "text/plain", bytes.NewBufferString(text))
+	if err != nil {
+		return driver.NewError(driver.ErrorTransient, formatError(method, url, err))
 	}
 
 	defer resp.Body.Close()
 	return checkRequestResult(method, url, resp.StatusCode, resp.Body)
 }
 
-func actionWithRetry(url, token string) error {
+func actionWithRetry(ctx context.Context, url, token string) error {
 	var err error
 	for i := 0; i < failedRetries; i++ {
-		err = action(url, token)
-		if err == nil {
-			return nil
+		err = action(ctx, url, token)
+		if err == nil || driver.ErrorTypeOf(err) == driver.ErrorAuthFailure {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return driver.NewError(driver.ErrorTransient, formatError(http.MethodPost, url, ctx.Err()))
+		case <-time.After(failedWaitTime):
 		}
-		time.Sleep(failedWaitTime)
 	}
 	return err
 }
 
-func action(url, token string) error {
+func action(ctx context.Context, url, token string) error {
 	method := http.MethodPost
 
-	resp, err := sendRequest(method, url, token, bytes.NewBuffer([]byte{}))
+	resp, err := sendRequest(ctx, method, url, token, bytes.NewBuffer([]byte{}))
 	if err != nil {
-		return formatError(method, url, err)
+		return driver.NewError(driver.ErrorTransient, formatError(method, url, err))
 	}
 
 	defer resp.Body.Close()
 	return checkRequestResult(method, url, resp.StatusCode, resp.Body)
 }
 
-func sendRequest(method, url, token string, reqBody io.Reader) (*http.Response, error) {
-	req, err := http.NewRequest(method, url, reqBody)
+func sendRequest(ctx context.Context, method, url, token string, reqBody io.Reader) (*http.Response, error) {
+	return sendRequestWithContentType(ctx, method, url, token, "application/json", reqBody)
+}
+
+func sendRequestWithContentType(ctx context.Context, method, url, token, contentType string, reqBody io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Add("Authorization", "Basic "+token)
 
 	cli := &http.Client{
@@ -156,10 +185,33 @@ func checkRequestResult(method, url string, code int, body io.ReadCloser) error
 			Message string `json:"message"`
 		}{}
 		json.Unmarshal(b, &errInfo)
-		return fmt.Errorf("%s %s failed with status %v : %s", method, url, code, errInfo.Message)
+		return driver.Errorf(getErrorType(code), "%s %s failed with status %v : %s", method, url, code, errInfo.Message)
 	}
 }
 
+// radware rejects requests conflicting with the current device state (e.g. object
+// still referenced or not applied yet) with 400 too, so it's retried as conflict,
+// invalid config is only reported by driver validation
+func getErrorType(code int) driver.ErrorType {
+	switch {
+	case code == http.StatusBadRequest || code == http.StatusConflict:
+		return driver.ErrorConflict
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return driver.ErrorAuthFailure
+	case code == http.StatusNotFound:
+		return driver.ErrorNotFound
+	case code == http.StatusTooManyRequests || code >= http.StatusInternalServerError:
+		return driver.ErrorTransient
+	default:
+		return driver.ErrorUnknown
+	}
+}
+
+func isIPEqual(ip1, ip2 string) bool {
+	i1 := net.ParseIP(ip1)
+	return i1 != nil && i1.Equal(net.ParseIP(ip2))
+}
+
 func formatError(method, url string, e error) error {
 	return fmt.Errorf("%s %s failed %s", method, url, e.Error())
 }
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/zdnscloud/elb-controller/driver/radware/types"
 )
@@ -22,42 +24,42 @@ func NewVirtualServerClient(token, serverAddr string) *VirtualServerClient {
 	}
 }
 
-func (c *VirtualServerClient) Reconcile(id string, vs *types.VirtualServer) error {
-	exist, err := c.get(id)
+func (c *VirtualServerClient) Reconcile(ctx context.Context, id string, vs *types.VirtualServer) error {
+	exist, err := c.get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
-			return c.create(id, vs)
+			return c.create(ctx, id, vs)
 		}
 		return err
 	}
 	if isVirtualServerEqual(exist, vs) {
 		return nil
 	}
-	return c.update(id, vs)
+	return c.update(ctx, id, vs)
 }
 
-func (c *VirtualServerClient) create(id string, obj *types.VirtualServer) error {
-	return create(c.genUrl(id), c.token, obj)
+func (c *VirtualServerClient) create(ctx context.Context, id string, obj *types.VirtualServer) error {
+	return create(ctx, c.genUrl(id), c.token, obj)
 }
 
-func (c *VirtualServerClient) update(id string, obj *types.VirtualServer) error {
-	return update(c.genUrl(id), c.token, obj)
+func (c *VirtualServerClient) update(ctx context.Context, id string, obj *types.VirtualServer) error {
+	return update(ctx, c.genUrl(id), c.token, obj)
 }
 
-func (c *VirtualServerClient) Delete(id string) error {
-	_, err := c.get(id)
+func (c *VirtualServerClient) Delete(ctx context.Context, id string) error {
+	_, err := c.get(ctx, id)
 	if err != nil {
 		if err == ResourceNotFoundError {
 			return nil
 		}
 		return err
 	}
-	return delete(c.genUrl(id), c.token)
+	return delete(ctx, c.genUrl(id), c.token)
 }
 
-func (c *VirtualServerClient) get(id string) (*types.VirtualServer, error) {
+func (c *VirtualServerClient) get(ctx context.Context, id string) (*types.VirtualServer, error) {
 	list := &types.VirtualServerList{}
-	if err := get(c.genUrl(id), c.token, list); err != nil {
+	if err := get(ctx, c.genUrl(id), c.token, list); err != nil {
 		return nil, err
 	}
 	if len(list.VSTable) == 0 {
@@ -66,13 +68,28 @@ func (c *VirtualServerClient) get(id string) (*types.VirtualServer, error) {
 	return &list.VSTable[0], nil
 }
 
+func (c *VirtualServerClient) List(ctx context.Context) ([]types.VirtualServer, error) {
+	list := &types.VirtualServerList{}
+	if err := get(ctx, c.genListUrl(), c.token, list); err != nil {
+		return nil, err
+	}
+	return list.VSTable, nil
+}
+
 func isVirtualServerEqual(v1, v2 *types.VirtualServer) bool {
 	if v1 == nil || v2 == nil {
 		return false
 	}
+	if v2.VirtServerIpVer == types.IpVer6 {
+		return v1.VirtServerIpVer == v2.VirtServerIpVer && isIPEqual(v1.VirtServerIpv6Addr, v2.VirtServerIpv6Addr) && v1.VirtServerState == v2.VirtServerState
+	}
 	return v1.VirtServerIpAddress == v2.VirtServerIpAddress && v1.VirtServerState == v2.VirtServerState
 }
 
 func (c *VirtualServerClient) genUrl(id string) string {
 	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, virtualServerPath, id)
 }
+
+func (c *VirtualServerClient) genListUrl() string {
+	return fmt.Sprintf("%s%s%s", reqUrlPrefix, c.server, strings.TrimSuffix(virtualServerPath, "/"))
+}
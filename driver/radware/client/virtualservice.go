@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/zdnscloud/elb-controller/driver/radware/types"
@@ -11,6 +12,9 @@ const (
 	virtualServiceRealGroupPath = "/config/SlbNewCfgEnhVirtServicesSeventhPartTable/"
 )
 
+// VirtualServiceClient virtual services of a virtual server are identified by
+// virtual port and protocol, their index is allocated when created, so that
+// services of different k8s services can share one virtual server
 type VirtualServiceClient struct {
 	token  string
 	server string
@@ -23,71 +27,89 @@ func NewVirtualServiceClient(token, serverAddr string) *VirtualServiceClient {
 	}
 }
 
-func (c *VirtualServiceClient) Reconcile(id string, vs *types.VirtualService) error {
-	exist, err := c.get(id)
+func (c *VirtualServiceClient) Reconcile(ctx context.Context, vsID string, vs *types.VirtualService, rg *types.VirtualServiceRealGroup) error {
+	services, err := c.List(ctx, vsID)
 	if err != nil {
-		if err == ResourceNotFoundError {
-			return c.create(id, vs)
-		}
 		return err
 	}
+	exist := findVirtualService(services, vs.VirtPort, vs.UDPBalance)
+	if exist == nil {
+		return c.create(ctx, vsID, allocVirtualServiceIndex(services), vs, rg)
+	}
 
-	existGroup, err := c.getRealGroup(id)
+	existGroup, err := c.getRealGroup(ctx, vsID, exist.Index)
 	if err != nil {
 		return err
 	}
 
-	if !isVirtualServiceRealGroupEqual(existGroup, types.NewVirtualServiceRealGroup(id)) {
-		if err := c.setRealGroup(id); err != nil {
+	if !isVirtualServiceRealGroupEqual(existGroup, rg) {
+		if err := c.setRealGroup(ctx, vsID, exist.Index, rg); err != nil {
 			return err
 		}
 	}
 	if isVirtualServiceEqual(exist, vs) {
 		return nil
 	}
-	return c.update(id, vs)
+	return c.update(ctx, vsID, exist.Index, vs)
 }
 
-func (c *VirtualServiceClient) Delete(id string) error {
-	_, err := c.get(id)
+func (c *VirtualServiceClient) Delete(ctx context.Context, vsID string, port, udpBalance int32) error {
+	exist, err := c.Get(ctx, vsID, port, udpBalance)
 	if err != nil {
 		if err == ResourceNotFoundError {
 			return nil
 		}
 		return err
 	}
-	return delete(c.genUrl(id), c.token)
+	return delete(ctx, c.genUrl(vsID, exist.Index), c.token)
 }
 
-func (c *VirtualServiceClient) create(id string, obj *types.VirtualService) error {
-	if err := create(c.genUrl(id), c.token, obj); err != nil {
+func (c *VirtualServiceClient) create(ctx context.Context, vsID string, index int, obj *types.VirtualService, rg *types.VirtualServiceRealGroup) error {
+	if err := create(ctx, c.genUrl(vsID, index), c.token, obj); err != nil {
 		return err
 	}
-	return c.setRealGroup(id)
+	return c.setRealGroup(ctx, vsID, index, rg)
 }
 
-func (c *VirtualServiceClient) update(id string, obj *types.VirtualService) error {
-	return update(c.genUrl(id), c.token, obj)
+func (c *VirtualServiceClient) update(ctx context.Context, vsID string, index int, obj *types.VirtualService) error {
+	return update(ctx, c.genUrl(vsID, index), c.token, obj)
 }
 
-func (c *VirtualServiceClient) setRealGroup(id string) error {
-	return update(c.genRealGroupUrl(id), c.token, types.NewVirtualServiceRealGroup(id))
+func (c *VirtualServiceClient) setRealGroup(ctx context.Context, vsID string, index int, rg *types.VirtualServiceRealGroup) error {
+	return update(ctx, c.genRealGroupUrl(vsID, index), c.token, rg)
 }
 
-func (c *VirtualServiceClient) get(id string) (*types.VirtualService, error) {
+func (c *VirtualServiceClient) Get(ctx context.Context, vsID string, port, udpBalance int32) (*types.VirtualService, error) {
+	services, err := c.List(ctx, vsID)
+	if err != nil {
+		return nil, err
+	}
+	if exist := findVirtualService(services, port, udpBalance); exist != nil {
+		return exist, nil
+	}
+	return nil, ResourceNotFoundError
+}
+
+// List returns the virtual services of virtual server vsID
+func (c *VirtualServiceClient) List(ctx context.Context, vsID string) ([]types.VirtualService, error) {
 	list := &types.VirtualServiceList{}
-	if err := get(c.genUrl(id), c.token, list); err != nil {
+	if err := get(ctx, c.genListUrl(vsID), c.token, list); err != nil {
 		return nil, err
 	}
-	if len(list.VSTable) == 0 {
-		return nil, ResourceNotFoundError
+	return list.VSTable, nil
+}
+
+func (c *VirtualServiceClient) GetRealGroup(ctx context.Context, vsID string, port, udpBalance int32) (*types.VirtualServiceRealGroup, error) {
+	exist, err := c.Get(ctx, vsID, port, udpBalance)
+	if err != nil {
+		return nil, err
 	}
-	return &list.VSTable[0], nil
+	return c.getRealGroup(ctx, vsID, exist.Index)
 }
 
-func (c *VirtualServiceClient) getRealGroup(id string) (*types.VirtualServiceRealGroup, error) {
+func (c *VirtualServiceClient) getRealGroup(ctx context.Context, vsID string, index int) (*types.VirtualServiceRealGroup, error) {
 	list := &types.VirtualServiceRealGroupList{}
-	if err := get(c.genRealGroupUrl(id), c.token, list); err != nil {
+	if err := get(ctx, c.genRealGroupUrl(vsID, index), c.token, list); err != nil {
 		return nil, err
 	}
 	if len(list.VSTable) == 0 {
@@ -96,11 +118,34 @@ func (c *VirtualServiceClient) getRealGroup(id string) (*types.VirtualServiceRea
 	return &list.VSTable[0], nil
 }
 
+func findVirtualService(services []types.VirtualService, port, udpBalance int32) *types.VirtualService {
+	for i := range services {
+		if services[i].VirtPort == port && services[i].UDPBalance == udpBalance {
+			return &services[i]
+		}
+	}
+	return nil
+}
+
+// allocVirtualServiceIndex returns the smallest index which isn't used
+func allocVirtualServiceIndex(services []types.VirtualService) int {
+	used := make(map[int]bool)
+	for _, s := range services {
+		used[s.Index] = true
+	}
+	index := 1
+	for used[index] {
+		index++
+	}
+	return index
+}
+
 func isVirtualServiceEqual(v1, v2 *types.VirtualService) bool {
 	if v1 == nil || v2 == nil {
 		return false
 	}
-	return v1.UDPBalance == v2.UDPBalance && v1.VirtPort == v2.VirtPort && v1.RealPort == v2.RealPort && v1.DBind == v2.DBind && v1.PBind == v2.PBind
+	return v1.UDPBalance == v2.UDPBalance && v1.VirtPort == v2.VirtPort && v1.RealPort == v2.RealPort && v1.DBind == v2.DBind && v1.PBind == v2.PBind &&
+		v1.SSLpol == v2.SSLpol && v1.ServCert == v2.ServCert && v1.SrcNetwork == v2.SrcNetwork
 }
 
 func isVirtualServiceRealGroupEqual(g1, g2 *types.VirtualServiceRealGroup) bool {
@@ -110,10 +155,14 @@ func isVirtualServiceRealGroupEqual(g1, g2 *types.VirtualServiceRealGroup) bool
 	return g1.RealGroup == g2.RealGroup && g1.ProxyIpMode == g2.ProxyIpMode && g1.PersistentTimeOut == g2.PersistentTimeOut
 }
 
-func (c *VirtualServiceClient) genUrl(id string) string {
-	return fmt.Sprintf("%s%s%s%s/1", reqUrlPrefix, c.server, virtualServicePath, id)
+func (c *VirtualServiceClient) genUrl(vsID string, index int) string {
+	return fmt.Sprintf("%s%s%s%s/%v", reqUrlPrefix, c.server, virtualServicePath, vsID, index)
+}
+
+func (c *VirtualServiceClient) genListUrl(vsID string) string {
+	return fmt.Sprintf("%s%s%s%s", reqUrlPrefix, c.server, virtualServicePath, vsID)
 }
 
-func (c *VirtualServiceClient) genRealGroupUrl(id string) string {
-	return fmt.Sprintf("%s%s%s%s/1", reqUrlPrefix, c.server, virtualServiceRealGroupPath, id)
+func (c *VirtualServiceClient) genRealGroupUrl(vsID string, index int) string {
+	return fmt.Sprintf("%s%s%s%s/%v", reqUrlPrefix, c.server, virtualServiceRealGroupPath, vsID, index)
 }
@@ -2,18 +2,41 @@ package radware
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/zdnscloud/elb-controller/driver"
 	"github.com/zdnscloud/elb-controller/driver/radware/types"
 )
 
+const (
+	defaultPersistentTimeout = 10
+	maxPersistentTimeout     = 2880
+	minRealServerWeight      = 1
+	defaultRealServerWeight  = 1
+	maxRealServerWeight      = 48
+)
+
 type radwareConfig struct {
 	RealServers    map[string]*types.RealServer
 	RealServerPort *types.RealServerPort
 	VsID           string
 	ServerGroup    *types.ServerGroup
-	VirtualServer  *types.VirtualServer
-	VirtualService *types.VirtualService
+	// VirtualServer is shared by all services with the same vip
+	VirtualServerID string
+	VirtualServer   *types.VirtualServer
+	VirtualService  *types.VirtualService
+	RealGroup       *types.VirtualServiceRealGroup
+	// HealthCheck nil means using builtin health check of the protocol
+	HealthCheckKind types.HealthCheckKind
+	HealthCheck     *types.HealthCheck
+	// TLS not nil means ssl offload, certificate and ssl policy use VsID as id
+	TLS       *driver.TLS
+	SSLPolicy *types.SSLPolicy
+	// NetworkElements nil means all clients are allowed, network class uses VsID as id
+	NetworkElements map[string]*types.NetworkElement
 }
 
 type updateRadwareConfig struct {
@@ -24,15 +47,26 @@ type updateRadwareConfig struct {
 func getRadwareConfigs(config driver.Config) []radwareConfig {
 	result := []radwareConfig{}
 
-	for _, s := range config.Services {
-		c := radwareConfig{}
-		c.RealServers = getRsmap(config, s)
-		c.RealServerPort = getRsport(s)
-		c.VsID = genVsID(s, config)
-		c.ServerGroup = getServerGroup(s, config)
-		c.VirtualServer = getVirtualServer(config)
-		c.VirtualService = getVirtualService(s)
-		result = append(result, c)
+	// dual-stack service has a virtual server for each vip
+	for _, vip := range config.VIPs() {
+		for _, s := range config.Services {
+			c := radwareConfig{}
+			c.RealServers = getRsmap(config, s, vip)
+			c.RealServerPort = getRsport(s)
+			c.VsID = genVsID(vip, s, config)
+			c.ServerGroup = getServerGroup(c.VsID, vip, s, config)
+			c.VirtualServerID = genVirtualServerID(vip, config)
+			c.VirtualServer = getVirtualServer(vip)
+			c.VirtualService = getVirtualService(c.VsID, s, config)
+			c.RealGroup = getVirtualServiceRealGroup(c.VsID, config)
+			c.HealthCheckKind, c.HealthCheck = getHealthCheck(s)
+			c.NetworkElements = getNetworkElements(vip, config)
+			if s.TLS != nil {
+				c.TLS = s.TLS
+				c.SSLPolicy = types.NewOffloadSSLPolicy()
+			}
+			result = append(result, c)
+		}
 	}
 
 	return result
@@ -108,31 +142,77 @@ func getToAddRsmap(old, new radwareConfig) map[string]*types.RealServer {
 	return result
 }
 
-func getRsmap(cfg driver.Config, s driver.Service) map[string]*types.RealServer {
+// getToUpdateRsmap returns the realservers exist in both old and new but changed, like weight
+func getToUpdateRsmap(old, new radwareConfig) map[string]*types.RealServer {
+	result := map[string]*types.RealServer{}
+	for k, v := range new.RealServers {
+		if o, ok := old.RealServers[k]; ok && *o != *v {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+func getRsmap(cfg driver.Config, s driver.Service, vip string) map[string]*types.RealServer {
 	result := map[string]*types.RealServer{}
-	for _, h := range s.BackendHosts {
-		id := genRealServerID(h, s.BackendPort, s.Protocol, cfg)
+	for _, h := range getBackendHosts(s, vip) {
+		id := genRealServerID(h, s, cfg)
 		rs := &types.RealServer{
 			IpAddr: h,
-			State:  2,
+			State:  types.RealServerStateEnabled,
 			Type:   1,
+			Weight: getRealServerWeight(s, h),
+		}
+		if !isIPv4(h) {
+			rs.IpAddr = ""
+			rs.IpVer = types.IpVer6
+			rs.Ipv6Addr = h
 		}
 		result[id] = rs
 	}
 	return result
 }
 
+func getRealServerWeight(s driver.Service, host string) int32 {
+	w, ok := s.BackendWeights[host]
+	if !ok {
+		return defaultRealServerWeight
+	}
+	if w < minRealServerWeight {
+		return minRealServerWeight
+	}
+	if w > maxRealServerWeight {
+		return maxRealServerWeight
+	}
+	return w
+}
+
+// getBackendHosts returns the backend hosts with the same ip family as vip
+func getBackendHosts(s driver.Service, vip string) []string {
+	if isIPv4(vip) {
+		return s.BackendHosts
+	}
+	return s.BackendHostsV6
+}
+
 func getRsport(s driver.Service) *types.RealServerPort {
 	return &types.RealServerPort{
 		RealPort: s.BackendPort,
 	}
 }
 
-func genRealServerID(nodeIP string, nodePort int32, protocol driver.Protocol, cfg driver.Config) string {
-	return fmt.Sprintf("%s_%s_%s_%s_%s_%v", cfg.K8sCluster, cfg.K8sNamespace, cfg.K8sService, nodeIP, protocol, nodePort)
+// genRealServerID host and port are node ip and node port, or pod ip and target
+// port in pod backend mode, pod ips never collide with node ips so the id format is shared,
+// service ports may have the same target port, so service port is appended in pod backend mode
+func genRealServerID(host string, s driver.Service, cfg driver.Config) string {
+	id := fmt.Sprintf("%s_%s_%s_%s_%s_%v", cfg.K8sCluster, cfg.K8sNamespace, cfg.K8sService, genIDAddr(host), s.Protocol, s.BackendPort)
+	if cfg.BackendMode == driver.BackendModePod {
+		id = fmt.Sprintf("%s_%v", id, s.Port)
+	}
+	return id
 }
 
-func getServerGroup(s driver.Service, c driver.Config) *types.ServerGroup {
+func getServerGroup(vsID, vip string, s driver.Service, c driver.Config) *types.ServerGroup {
 	result := &types.ServerGroup{
 		Metric:   1,     // default use round robin
 		HealthID: "tcp", // default use tcp healthcheck
@@ -144,33 +224,262 @@ func getServerGroup(s driver.Service, c driver.Config) *types.ServerGroup {
 		result.Metric = 4
 	}
 
-	if s.Protocol == driver.ProtocolUDP {
+	switch s.Protocol {
+	case driver.ProtocolUDP:
 		result.HealthID = "udp"
+	case driver.ProtocolSCTP:
+		result.HealthID = "icmp" // tcp healthcheck can't connect to sctp port
+	}
+	if s.HealthCheck != nil {
+		result.HealthID = vsID
+	}
+	if !isIPv4(vip) {
+		result.IpVer = types.IpVer6
+	}
+	return result
+}
+
+func getHealthCheck(s driver.Service) (types.HealthCheckKind, *types.HealthCheck) {
+	if s.HealthCheck == nil {
+		return "", nil
+	}
+
+	hc := &types.HealthCheck{
+		Interval: s.HealthCheck.Interval,
+		Timeout:  s.HealthCheck.Timeout,
+		Retries:  s.HealthCheck.Retries,
+	}
+	switch s.HealthCheck.Type {
+	case driver.HealthCheckUDP:
+		return types.HealthCheckKindUdp, hc
+	case driver.HealthCheckICMP:
+		return types.HealthCheckKindIcmp, hc
+	case driver.HealthCheckHTTP, driver.HealthCheckHTTPS:
+		hc.Https = 2
+		if s.HealthCheck.Type == driver.HealthCheckHTTPS {
+			hc.Https = 1
+		}
+		hc.Path = s.HealthCheck.Path
+		if hc.Path == "" {
+			hc.Path = "/"
+		}
+		hc.RCode = "200"
+		if s.HealthCheck.ExpectedStatus != 0 {
+			hc.RCode = strconv.Itoa(s.HealthCheck.ExpectedStatus)
+		}
+		return types.HealthCheckKindHttp, hc
+	default:
+		return types.HealthCheckKindTcp, hc
+	}
+}
+
+func getDriverHealthCheck(kind types.HealthCheckKind, hc *types.HealthCheck) *driver.HealthCheck {
+	result := &driver.HealthCheck{
+		Interval: hc.Interval,
+		Timeout:  hc.Timeout,
+		Retries:  hc.Retries,
+	}
+	switch kind {
+	case types.HealthCheckKindUdp:
+		result.Type = driver.HealthCheckUDP
+	case types.HealthCheckKindIcmp:
+		result.Type = driver.HealthCheckICMP
+	case types.HealthCheckKindHttp:
+		result.Type = driver.HealthCheckHTTP
+		if hc.Https == 1 {
+			result.Type = driver.HealthCheckHTTPS
+		}
+		result.Path = hc.Path
+		result.ExpectedStatus, _ = strconv.Atoi(hc.RCode)
+	default:
+		result.Type = driver.HealthCheckTCP
 	}
 	return result
 }
 
-func genVsID(service driver.Service, cfg driver.Config) string {
-	return fmt.Sprintf("%s_%s_%s_%s_%s_%v", cfg.K8sCluster, cfg.K8sNamespace, cfg.K8sService, cfg.VIP, service.Protocol, service.Port)
+func genVsID(vip string, service driver.Service, cfg driver.Config) string {
+	return fmt.Sprintf("%s_%s_%s_%s_%s_%v", cfg.K8sCluster, cfg.K8sNamespace, cfg.K8sService, genIDAddr(vip), service.Protocol, service.Port)
+}
+
+// genVirtualServerID virtual server is per vip, so services with the same vip share it
+func genVirtualServerID(vip string, cfg driver.Config) string {
+	return genVirtualServerIDByCluster(cfg.K8sCluster, vip)
+}
+
+func genVirtualServerIDByCluster(k8sCluster, vip string) string {
+	return fmt.Sprintf("%s_%s", k8sCluster, genIDAddr(vip))
+}
+
+// genIDAddr replaces ':' of ipv6 address which isn't allowed in id, ipv4 address never contains '-'
+func genIDAddr(ip string) string {
+	return strings.Replace(ip, ":", "-", -1)
 }
 
-func getVirtualServer(c driver.Config) *types.VirtualServer {
+func parseIDAddr(s string) string {
+	return strings.Replace(s, "-", ":", -1)
+}
+
+type vsKey struct {
+	K8sNamespace string
+	K8sService   string
+	VIP          string
+	Protocol     driver.Protocol
+	Port         int32
+}
+
+// parseVsID is the reverse of genVsID, namespace and service name never contain '_'
+func parseVsID(k8sCluster, id string) (vsKey, bool) {
+	prefix := k8sCluster + "_"
+	if !strings.HasPrefix(id, prefix) {
+		return vsKey{}, false
+	}
+	fields := strings.Split(strings.TrimPrefix(id, prefix), "_")
+	if len(fields) != 5 {
+		return vsKey{}, false
+	}
+	port, err := strconv.ParseInt(fields[4], 10, 32)
+	if err != nil {
+		return vsKey{}, false
+	}
+	return vsKey{
+		K8sNamespace: fields[0],
+		K8sService:   fields[1],
+		VIP:          parseIDAddr(fields[2]),
+		Protocol:     driver.Protocol(fields[3]),
+		Port:         int32(port),
+	}, true
+}
+
+func getLBMethod(sg *types.ServerGroup) driver.LoadBalanceMethod {
+	switch sg.Metric {
+	case 2:
+		return driver.LBMethodLeastConnections
+	case 4:
+		return driver.LBMethodHash
+	default:
+		return driver.LBMethodRoundRobin
+	}
+}
+
+func getVirtualServer(vip string) *types.VirtualServer {
+	if !isIPv4(vip) {
+		return &types.VirtualServer{
+			VirtServerIpVer:    types.IpVer6,
+			VirtServerIpv6Addr: vip,
+			VirtServerState:    2,
+		}
+	}
 	return &types.VirtualServer{
-		VirtServerIpAddress: c.VIP,
+		VirtServerIpAddress: vip,
 		VirtServerState:     2,
 	}
 }
 
-func getVirtualService(s driver.Service) *types.VirtualService {
+func getVirtualService(vsID string, s driver.Service, c driver.Config) *types.VirtualService {
 	result := &types.VirtualService{
-		UDPBalance: 3, // default tcp service type
+		UDPBalance: getUDPBalance(s.Protocol),
 		VirtPort:   s.Port,
 		RealPort:   s.BackendPort,
 		DBind:      2,
-		PBind:      2,
+		PBind:      3, // default disable persistent binding
+	}
+	if c.Persistence != nil && c.Persistence.Type == driver.PersistenceClientIP {
+		result.PBind = 2
+	}
+	if len(c.SourceRanges) > 0 {
+		result.SrcNetwork = vsID
+	}
+	if s.TLS != nil {
+		result.DBind = 3
+		result.SSLpol = vsID
+		result.ServCert = vsID
+	}
+	return result
+}
+
+// getNetworkElements returns the source ranges of the vip address family, they are validated before
+func getNetworkElements(vip string, c driver.Config) map[string]*types.NetworkElement {
+	if len(c.SourceRanges) == 0 {
+		return nil
+	}
+	v6 := !isIPv4(vip)
+	result := make(map[string]*types.NetworkElement)
+	for _, r := range c.SourceRanges {
+		_, ipnet, _ := net.ParseCIDR(r)
+		if ipnet == nil || (ipnet.IP.To4() == nil) != v6 {
+			continue
+		}
+		e := &types.NetworkElement{
+			NetType:   2,
+			MatchType: 1,
+			Net:       ipnet.IP.String(),
+		}
+		if v6 {
+			e.IpVer = types.IpVer6
+			e.Prefix, _ = ipnet.Mask.Size()
+		} else {
+			e.Mask = net.IP(ipnet.Mask).String()
+		}
+		result[strconv.Itoa(len(result)+1)] = e
+	}
+	return result
+}
+
+// getSourceRanges is the reverse of getNetworkElements
+func getSourceRanges(elements map[string]*types.NetworkElement) []string {
+	result := []string{}
+	for _, e := range elements {
+		if e.IpVer == types.IpVer6 {
+			result = append(result, fmt.Sprintf("%s/%v", e.Net, e.Prefix))
+		} else if mask := net.ParseIP(e.Mask); mask != nil {
+			ones, _ := net.IPMask(mask.To4()).Size()
+			result = append(result, fmt.Sprintf("%s/%v", e.Net, ones))
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func getUDPBalance(p driver.Protocol) int32 {
+	switch p {
+	case driver.ProtocolUDP:
+		return 2
+	case driver.ProtocolSCTP:
+		return 6
+	default:
+		return 3
+	}
+}
+
+// isCertificateChanged returns whether new certificate should be imported
+func isCertificateChanged(old, new radwareConfig) bool {
+	if new.TLS == nil {
+		return false
+	}
+	return old.TLS == nil || old.TLS.Certificate != new.TLS.Certificate || old.TLS.Key != new.TLS.Key
+}
+
+func getVirtualServiceRealGroup(vsID string, c driver.Config) *types.VirtualServiceRealGroup {
+	timeout := defaultPersistentTimeout
+	if c.Persistence != nil && c.Persistence.Timeout > 0 {
+		// radware persistent timeout is in minutes
+		timeout = int((c.Persistence.Timeout + 59) / 60)
+		if timeout > maxPersistentTimeout {
+			timeout = maxPersistentTimeout
+		}
+	}
+	return types.NewVirtualServiceRealGroup(vsID, timeout)
+}
+
+func getPersistence(vs *types.VirtualService, rg *types.VirtualServiceRealGroup) *driver.Persistence {
+	if vs.PBind != 2 {
+		return nil
+	}
+	result := &driver.Persistence{
+		Type: driver.PersistenceClientIP,
 	}
-	if s.Protocol == driver.ProtocolUDP {
-		result.UDPBalance = 2 // set virtual service type udp
+	if rg != nil {
+		result.Timeout = int32(rg.PersistentTimeOut * 60)
 	}
 	return result
 }
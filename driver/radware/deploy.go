@@ -1,74 +1,207 @@
 package radware
 
 import (
+	"context"
+
 	"github.com/zdnscloud/elb-controller/driver/radware/client"
+	"github.com/zdnscloud/elb-controller/driver/radware/types"
 )
 
-func (c radwareConfig) delete(cli *client.Client) error {
-	if err := cli.VirtualServer().Delete(c.VsID); err != nil {
+func (c radwareConfig) delete(ctx context.Context, cli *client.Client) error {
+	if err := cli.VirtualService().Delete(ctx, c.VirtualServerID, c.VirtualService.VirtPort, c.VirtualService.UDPBalance); err != nil {
+		return err
+	}
+	if err := deleteUnusedVirtualServer(ctx, cli, c.VirtualServerID); err != nil {
 		return err
 	}
+	if c.NetworkElements != nil {
+		if err := cli.NetworkClass().Delete(ctx, c.VsID); err != nil {
+			return err
+		}
+	}
+	if c.TLS != nil {
+		if err := deleteSSLOffload(ctx, cli, c.VsID); err != nil {
+			return err
+		}
+	}
 
-	if err := cli.ServerGroup().Delete(c.VsID); err != nil {
+	if err := cli.ServerGroup().Delete(ctx, c.VsID); err != nil {
 		return err
 	}
 
+	if c.HealthCheck != nil {
+		if err := cli.HealthCheck().Delete(ctx, c.HealthCheckKind, c.VsID); err != nil {
+			return err
+		}
+	}
+
 	for rs := range c.RealServers {
-		if err := cli.RealServer().Delete(rs); err != nil {
+		if err := cli.RealServer().Delete(ctx, rs); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func (c radwareConfig) create(cli *client.Client) error {
-	if err := cli.ServerGroup().Reconcile(c.VsID, c.ServerGroup); err != nil {
+func (c radwareConfig) create(ctx context.Context, cli *client.Client) error {
+	if c.HealthCheck != nil {
+		if err := cli.HealthCheck().Reconcile(ctx, c.HealthCheckKind, c.VsID, c.HealthCheck); err != nil {
+			return err
+		}
+	}
+
+	if err := cli.ServerGroup().Reconcile(ctx, c.VsID, c.ServerGroup); err != nil {
 		return err
 	}
 
 	for k, v := range c.RealServers {
-		if err := cli.RealServer().Reconcile(k, v); err != nil {
+		if err := cli.RealServer().Reconcile(ctx, k, v); err != nil {
 			return err
 		}
-		if err := cli.RealServerPort().Reconcile(k, c.RealServerPort); err != nil {
+		if err := cli.RealServerPort().Reconcile(ctx, k, c.RealServerPort); err != nil {
 			return err
 		}
-		if err := cli.ServerGroup().ReconcileServer(c.VsID, k); err != nil {
+		if err := cli.ServerGroup().ReconcileServer(ctx, c.VsID, k); err != nil {
 			return err
 		}
 	}
 
-	if err := cli.VirtualServer().Reconcile(c.VsID, c.VirtualServer); err != nil {
+	if err := cli.VirtualServer().Reconcile(ctx, c.VirtualServerID, c.VirtualServer); err != nil {
 		return err
 	}
-	return cli.VirtualService().Reconcile(c.VsID, c.VirtualService)
+
+	if c.NetworkElements != nil {
+		if err := cli.NetworkClass().Reconcile(ctx, c.VsID, c.NetworkElements); err != nil {
+			return err
+		}
+	}
+	if c.TLS != nil {
+		if err := cli.Certificate().Import(ctx, c.VsID, c.TLS.Certificate, c.TLS.Key); err != nil {
+			return err
+		}
+		if err := cli.SSLPolicy().Reconcile(ctx, c.VsID, c.SSLPolicy); err != nil {
+			return err
+		}
+	}
+	return cli.VirtualService().Reconcile(ctx, c.VirtualServerID, c.VirtualService, c.RealGroup)
 }
 
-func (c updateRadwareConfig) update(cli *client.Client) error {
-	if err := cli.ServerGroup().Reconcile(c.new.VsID, c.new.ServerGroup); err != nil {
+func (c updateRadwareConfig) update(ctx context.Context, cli *client.Client) error {
+	if c.new.HealthCheck != nil {
+		if err := cli.HealthCheck().Reconcile(ctx, c.new.HealthCheckKind, c.new.VsID, c.new.HealthCheck); err != nil {
+			return err
+		}
+	}
+
+	if err := cli.ServerGroup().Reconcile(ctx, c.new.VsID, c.new.ServerGroup); err != nil {
 		return err
 	}
 
-	if err := cli.VirtualService().Reconcile(c.new.VsID, c.new.VirtualService); err != nil {
+	// old health check can only be deleted after server group doesn't refer to it
+	if c.old.HealthCheck != nil && (c.new.HealthCheck == nil || c.old.HealthCheckKind != c.new.HealthCheckKind) {
+		if err := cli.HealthCheck().Delete(ctx, c.old.HealthCheckKind, c.old.VsID); err != nil {
+			return err
+		}
+	}
+
+	if isCertificateChanged(c.old, c.new) {
+		if err := cli.Certificate().Import(ctx, c.new.VsID, c.new.TLS.Certificate, c.new.TLS.Key); err != nil {
+			return err
+		}
+	}
+	if c.new.TLS != nil {
+		if err := cli.SSLPolicy().Reconcile(ctx, c.new.VsID, c.new.SSLPolicy); err != nil {
+			return err
+		}
+	}
+	if c.new.NetworkElements != nil {
+		if err := cli.NetworkClass().Reconcile(ctx, c.new.VsID, c.new.NetworkElements); err != nil {
+			return err
+		}
+	}
+
+	if err := cli.VirtualServer().Reconcile(ctx, c.new.VirtualServerID, c.new.VirtualServer); err != nil {
+		return err
+	}
+	if err := cli.VirtualService().Reconcile(ctx, c.new.VirtualServerID, c.new.VirtualService, c.new.RealGroup); err != nil {
 		return err
 	}
 
-	for toDeleteRs := range getToDeleteRsmap(c.old, c.new) {
-		if err := cli.RealServer().Delete(toDeleteRs); err != nil {
+	// ssl policy, certificate and network class can only be deleted after virtual service doesn't refer to them
+	if c.old.TLS != nil && c.new.TLS == nil {
+		if err := deleteSSLOffload(ctx, cli, c.old.VsID); err != nil {
+			return err
+		}
+	}
+	if c.old.NetworkElements != nil && c.new.NetworkElements == nil {
+		if err := cli.NetworkClass().Delete(ctx, c.old.VsID); err != nil {
+			return err
+		}
+	}
+
+	for toUpdateRsID, toUpdateRs := range getToUpdateRsmap(c.old, c.new) {
+		if err := cli.RealServer().Reconcile(ctx, toUpdateRsID, toUpdateRs); err != nil {
 			return err
 		}
 	}
 
 	for toAddRsID, toAddRs := range getToAddRsmap(c.old, c.new) {
-		if err := cli.RealServer().Reconcile(toAddRsID, toAddRs); err != nil {
+		if err := cli.RealServer().Reconcile(ctx, toAddRsID, toAddRs); err != nil {
+			return err
+		}
+		if err := cli.RealServerPort().Reconcile(ctx, toAddRsID, c.new.RealServerPort); err != nil {
+			return err
+		}
+		if err := cli.ServerGroup().ReconcileServer(ctx, c.new.VsID, toAddRsID); err != nil {
 			return err
 		}
-		if err := cli.RealServerPort().Reconcile(toAddRsID, c.new.RealServerPort); err != nil {
+	}
+	return nil
+}
+
+// removeRealServers is called after update and drain, so that the service
+// always has the new realservers before the old ones are removed
+func (c updateRadwareConfig) removeRealServers(ctx context.Context, cli *client.Client) error {
+	for toDeleteRs := range getToDeleteRsmap(c.old, c.new) {
+		if err := cli.ServerGroup().RemoveServer(ctx, c.old.VsID, toDeleteRs); err != nil {
 			return err
 		}
-		if err := cli.ServerGroup().ReconcileServer(c.new.VsID, toAddRsID); err != nil {
+		if err := cli.RealServer().Delete(ctx, toDeleteRs); err != nil {
 			return err
 		}
 	}
 	return nil
 }
+
+func deleteSSLOffload(ctx context.Context, cli *client.Client, id string) error {
+	if err := cli.SSLPolicy().Delete(ctx, id); err != nil {
+		return err
+	}
+	return cli.Certificate().Delete(ctx, id)
+}
+
+// deleteUnusedVirtualServer deletes the virtual server after its last service is deleted
+func deleteUnusedVirtualServer(ctx context.Context, cli *client.Client, id string) error {
+	services, err := cli.VirtualService().List(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(services) > 0 {
+		return nil
+	}
+	return cli.VirtualServer().Delete(ctx, id)
+}
+
+// drain disables the realservers to be deleted, so that they don't accept new
+// connections but the established ones are kept, it returns whether any is disabled
+func (c updateRadwareConfig) drain(ctx context.Context, cli *client.Client) (bool, error) {
+	toDelete := getToDeleteRsmap(c.old, c.new)
+	for id, rs := range toDelete {
+		disabled := *rs
+		disabled.State = types.RealServerStateDisabled
+		if err := cli.RealServer().Reconcile(ctx, id, &disabled); err != nil {
+			return false, err
+		}
+	}
+	return len(toDelete) > 0, nil
+}
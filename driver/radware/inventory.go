@@ -0,0 +1,217 @@
+package radware
+
+import (
+	"context"
+	"sort"
+
+	"github.com/zdnscloud/elb-controller/driver"
+	"github.com/zdnscloud/elb-controller/driver/radware/client"
+	"github.com/zdnscloud/elb-controller/driver/radware/types"
+)
+
+func inventory(ctx context.Context, cli *client.Client, k8sCluster string) ([]driver.Config, error) {
+	ids, err := listVsIDs(ctx, cli, k8sCluster)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make(map[string]*driver.Config)
+	keys := []string{}
+	for _, id := range ids {
+		key, _ := parseVsID(k8sCluster, id)
+		name := key.K8sNamespace + "/" + key.K8sService
+		c, ok := configs[name]
+		if !ok {
+			c = &driver.Config{
+				K8sCluster:   k8sCluster,
+				K8sNamespace: key.K8sNamespace,
+				K8sService:   key.K8sService,
+				Method:       driver.LBMethodRoundRobin,
+				Services:     []driver.Service{},
+			}
+			configs[name] = c
+			keys = append(keys, name)
+		}
+		if err := observeService(ctx, cli, id, key, c); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Strings(keys)
+	result := make([]driver.Config, 0, len(keys))
+	for _, k := range keys {
+		result = append(result, *configs[k])
+	}
+	return result, nil
+}
+
+// listVsIDs collects the ids of both legacy per port virtual servers and server groups, so that half created or half deleted configs are observed too
+func listVsIDs(ctx context.Context, cli *client.Client, k8sCluster string) ([]string, error) {
+	ids := make(map[string]bool)
+	vss, err := cli.VirtualServer().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, vs := range vss {
+		if _, ok := parseVsID(k8sCluster, vs.VirtServerIndex); ok {
+			ids[vs.VirtServerIndex] = true
+		}
+	}
+
+	sgs, err := cli.ServerGroup().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, sg := range sgs {
+		if _, ok := parseVsID(k8sCluster, sg.Index); ok {
+			ids[sg.Index] = true
+		}
+	}
+
+	result := make([]string, 0, len(ids))
+	for id := range ids {
+		result = append(result, id)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// observeService appends the service of vsID to c, config level fields are overwritten by the last service
+func observeService(ctx context.Context, cli *client.Client, vsID string, key vsKey, c *driver.Config) error {
+	s := driver.Service{
+		Port:         key.Port,
+		Protocol:     key.Protocol,
+		BackendHosts: []string{},
+	}
+	v6 := !isIPv4(key.VIP)
+	if v6 {
+		c.VIPv6 = key.VIP
+	} else {
+		c.VIP = key.VIP
+	}
+
+	virtualServerID := genVirtualServerIDByCluster(c.K8sCluster, key.VIP)
+	udpBalance := getUDPBalance(key.Protocol)
+	vs, err := cli.VirtualService().Get(ctx, virtualServerID, key.Port, udpBalance)
+	if err != nil && err != client.ResourceNotFoundError {
+		return err
+	}
+	if vs != nil {
+		s.BackendPort = vs.RealPort
+		if vs.SrcNetwork != "" {
+			elements, err := cli.NetworkClass().GetElements(ctx, vs.SrcNetwork)
+			if err != nil {
+				return err
+			}
+			c.SourceRanges = mergeSourceRanges(c.SourceRanges, getSourceRanges(elements))
+		}
+		if vs.ServCert != "" {
+			// certificate content isn't observable, only whether tls is offloaded
+			s.TLS = &driver.TLS{}
+		}
+		rg, err := cli.VirtualService().GetRealGroup(ctx, virtualServerID, key.Port, udpBalance)
+		if err != nil && err != client.ResourceNotFoundError {
+			return err
+		}
+		c.Persistence = getPersistence(vs, rg)
+	}
+
+	sg, err := cli.ServerGroup().Get(ctx, vsID)
+	if err != nil {
+		if err == client.ResourceNotFoundError {
+			addObservedService(c, s, v6)
+			return nil
+		}
+		return err
+	}
+	c.Method = getLBMethod(sg)
+	if sg.HealthID == vsID {
+		if s.HealthCheck, err = observeHealthCheck(ctx, cli, vsID); err != nil {
+			return err
+		}
+	}
+
+	servers, err := cli.ServerGroup().GetServers(ctx, vsID)
+	if err != nil {
+		return err
+	}
+	for _, gs := range servers {
+		rs, err := cli.RealServer().Get(ctx, gs.Index)
+		if err != nil {
+			if err == client.ResourceNotFoundError {
+				continue
+			}
+			return err
+		}
+		host := rs.IpAddr
+		if v6 {
+			host = rs.Ipv6Addr
+		}
+		s.BackendHosts = append(s.BackendHosts, host)
+		if rs.Weight != 0 && rs.Weight != defaultRealServerWeight {
+			if s.BackendWeights == nil {
+				s.BackendWeights = make(map[string]int32)
+			}
+			s.BackendWeights[host] = rs.Weight
+		}
+	}
+	sort.Strings(s.BackendHosts)
+	addObservedService(c, s, v6)
+	return nil
+}
+
+// addObservedService merges the ipv4 and ipv6 virtual services of the same port into one service
+func addObservedService(c *driver.Config, s driver.Service, v6 bool) {
+	if v6 {
+		s.BackendHostsV6, s.BackendHosts = s.BackendHosts, []string{}
+	}
+	for i, exist := range c.Services {
+		if exist.Port != s.Port || exist.Protocol != s.Protocol {
+			continue
+		}
+		if v6 {
+			c.Services[i].BackendHostsV6 = s.BackendHostsV6
+		} else {
+			c.Services[i].BackendHosts = s.BackendHosts
+		}
+		for host, w := range s.BackendWeights {
+			if c.Services[i].BackendWeights == nil {
+				c.Services[i].BackendWeights = make(map[string]int32)
+			}
+			c.Services[i].BackendWeights[host] = w
+		}
+		return
+	}
+	c.Services = append(c.Services, s)
+}
+
+func observeHealthCheck(ctx context.Context, cli *client.Client, vsID string) (*driver.HealthCheck, error) {
+	for _, kind := range []types.HealthCheckKind{types.HealthCheckKindTcp, types.HealthCheckKindUdp, types.HealthCheckKindHttp, types.HealthCheckKindIcmp} {
+		hc, err := cli.HealthCheck().Get(ctx, kind, vsID)
+		if err == nil {
+			return getDriverHealthCheck(kind, hc), nil
+		}
+		if err != client.ResourceNotFoundError {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// mergeSourceRanges merges the ranges of ipv4 and ipv6 virtual services
+func mergeSourceRanges(ranges, others []string) []string {
+	for _, o := range others {
+		var found bool
+		for _, r := range ranges {
+			if r == o {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ranges = append(ranges, o)
+		}
+	}
+	sort.Strings(ranges)
+	return ranges
+}
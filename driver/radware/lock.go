@@ -0,0 +1,127 @@
+package radware
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/zdnscloud/elb-controller/driver/radware/client"
+)
+
+// deviceLock coordinates concurrent tasks on the same device, the device applies
+// all pending changes at once, so building objects holds the read lock and applying
+// holds the write lock, apply never commits objects which are half built by others;
+// objects of a vip, like the shared virtual server and its service indexes, are
+// built by one task at a time; objects built by a failed task aren't reverted,
+// since the device can only revert all pending changes including the ones of other
+// tasks, they are applied by the next apply of any task, and the failed task is
+// retried to build the rest of them; the device is dirty once any build changes
+// objects, and only dirty device is saved, so resync of unchanged configs doesn't
+// write the flash
+type deviceLock struct {
+	config sync.RWMutex
+	lock   sync.Mutex
+	vips   map[string]*vipLock
+	dirty  bool
+}
+
+type vipLock struct {
+	sync.Mutex
+	refs int
+}
+
+func newDeviceLock() *deviceLock {
+	return &deviceLock{
+		vips: make(map[string]*vipLock),
+	}
+}
+
+// lockVIPs locks vips in order to avoid deadlock, the returned function unlocks them
+func (l *deviceLock) lockVIPs(vips []string) func() {
+	sorted := make([]string, 0, len(vips))
+	seen := make(map[string]bool)
+	for _, vip := range vips {
+		if !seen[vip] {
+			seen[vip] = true
+			sorted = append(sorted, vip)
+		}
+	}
+	sort.Strings(sorted)
+
+	locks := make([]*vipLock, 0, len(sorted))
+	for _, vip := range sorted {
+		locks = append(locks, l.refVIP(vip))
+	}
+	for _, vl := range locks {
+		vl.Lock()
+	}
+	return func() {
+		for i := len(locks) - 1; i >= 0; i-- {
+			locks[i].Unlock()
+			l.unrefVIP(sorted[i])
+		}
+	}
+}
+
+func (l *deviceLock) refVIP(vip string) *vipLock {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	vl, ok := l.vips[vip]
+	if !ok {
+		vl = &vipLock{}
+		l.vips[vip] = vl
+	}
+	vl.refs += 1
+	return vl
+}
+
+func (l *deviceLock) unrefVIP(vip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	vl := l.vips[vip]
+	vl.refs -= 1
+	if vl.refs == 0 {
+		delete(l.vips, vip)
+	}
+}
+
+// build marks the device dirty if fn changes any object, even if it fails, since
+// the objects built before failure are pending too
+func (l *deviceLock) build(ctx context.Context, fn func(context.Context) error) error {
+	l.config.RLock()
+	defer l.config.RUnlock()
+	ctx, changes := client.WithChanges(ctx)
+	err := fn(ctx)
+	if changes.Changed() {
+		l.lock.Lock()
+		l.dirty = true
+		l.lock.Unlock()
+	}
+	return err
+}
+
+func (l *deviceLock) apply(fn func() error) error {
+	l.config.Lock()
+	defer l.config.Unlock()
+	return fn()
+}
+
+// save skips fn if the device isn't dirty, the write lock blocks builds, so the
+// device is clean after fn succeeds
+func (l *deviceLock) save(fn func() error) error {
+	l.config.Lock()
+	defer l.config.Unlock()
+	l.lock.Lock()
+	dirty := l.dirty
+	l.lock.Unlock()
+	if !dirty {
+		return nil
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	l.lock.Lock()
+	l.dirty = false
+	l.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,70 @@
+package radware
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/zdnscloud/elb-controller/driver/radware/client"
+)
+
+// legacyMigration remembers the vips whose virtual servers created per service port
+// by older versions have been deleted, so the device is only listed once for each vip
+type legacyMigration struct {
+	lock     sync.Mutex
+	migrated map[string]bool
+}
+
+func newLegacyMigration() *legacyMigration {
+	return &legacyMigration{
+		migrated: make(map[string]bool),
+	}
+}
+
+// migrate deletes the legacy virtual servers of vips before the shared virtual
+// server is built, otherwise the vip is duplicated, legacy virtual servers of other
+// services with the same vip are deleted too, they are rebuilt by the tasks of their
+// services; the vip locks should be held
+func (m *legacyMigration) migrate(ctx context.Context, cli *client.Client, k8sCluster string, vips []string) error {
+	todo := make(map[string]string)
+	m.lock.Lock()
+	for _, vip := range vips {
+		if id := genVirtualServerIDByCluster(k8sCluster, vip); !m.migrated[id] {
+			todo[id] = vip
+		}
+	}
+	m.lock.Unlock()
+	if len(todo) == 0 {
+		return nil
+	}
+
+	vss, err := cli.VirtualServer().List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, vs := range vss {
+		key, ok := parseVsID(k8sCluster, vs.VirtServerIndex)
+		if !ok {
+			continue
+		}
+		for _, vip := range todo {
+			if isSameIP(key.VIP, vip) {
+				if err := cli.VirtualServer().Delete(ctx, vs.VirtServerIndex); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for id := range todo {
+		m.migrated[id] = true
+	}
+	return nil
+}
+
+func isSameIP(ip1, ip2 string) bool {
+	i1 := net.ParseIP(ip1)
+	return i1 != nil && i1.Equal(net.ParseIP(ip2))
+}
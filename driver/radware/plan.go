@@ -0,0 +1,209 @@
+package radware
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zdnscloud/elb-controller/driver"
+	"github.com/zdnscloud/elb-controller/driver/radware/types"
+)
+
+const (
+	objectHealthCheck    = "healthcheck"
+	objectServerGroup    = "servergroup"
+	objectRealServer     = "realserver"
+	objectRealServerPort = "realserverport"
+	objectVirtualServer  = "virtualserver"
+	objectVirtualService = "virtualservice"
+	objectCertificate    = "certificate"
+	objectSSLPolicy      = "sslpolicy"
+	objectNetworkClass   = "networkclass"
+)
+
+// plan functions mirror the ones in deploy.go, they are computed from configs
+// only, so objects which already exist on the device are planned as create too,
+// and legacy per port virtual servers deletion isn't planned
+
+func planCreate(configs []radwareConfig) []driver.Operation {
+	ops := []driver.Operation{}
+	for _, c := range configs {
+		ops = append(ops, c.planCreate()...)
+	}
+	return ops
+}
+
+func planDelete(configs []radwareConfig) []driver.Operation {
+	ops := []driver.Operation{}
+	for _, c := range configs {
+		ops = append(ops, c.planDelete()...)
+	}
+	return ops
+}
+
+func planUpdate(olds, news []radwareConfig, drainSeconds int32) []driver.Operation {
+	ops := []driver.Operation{}
+	for _, toD := range getToDeleteRdConfigs(olds, news) {
+		ops = append(ops, toD.planDelete()...)
+	}
+	for _, toA := range getToAddRdConfigs(olds, news) {
+		ops = append(ops, toA.planCreate()...)
+	}
+	updates := getUpdateRdConfigs(olds, news)
+	for _, toU := range updates {
+		ops = append(ops, toU.planUpdate()...)
+	}
+	if drainSeconds > 0 {
+		for _, toU := range updates {
+			ops = append(ops, toU.planDrain(drainSeconds)...)
+		}
+	}
+	for _, toU := range updates {
+		ops = append(ops, toU.planRemoveRealServers()...)
+	}
+	return ops
+}
+
+func (c radwareConfig) planCreate() []driver.Operation {
+	ops := []driver.Operation{}
+	if c.HealthCheck != nil {
+		ops = append(ops, newOperation(driver.OperationCreate, objectHealthCheck, c.VsID, fmt.Sprintf("%s %s", c.HealthCheckKind, c.HealthCheck.ToJson())))
+	}
+	ops = append(ops, newOperation(driver.OperationCreate, objectServerGroup, c.VsID, c.ServerGroup.ToJson()))
+	ops = append(ops, planAddRealServers(c.VsID, c.RealServers, c.RealServerPort)...)
+	ops = append(ops, newOperation(driver.OperationCreate, objectVirtualServer, c.VirtualServerID, c.VirtualServer.ToJson()))
+	if c.NetworkElements != nil {
+		ops = append(ops, newOperation(driver.OperationCreate, objectNetworkClass, c.VsID, strings.Join(getSourceRanges(c.NetworkElements), ",")))
+	}
+	if c.TLS != nil {
+		ops = append(ops, newOperation(driver.OperationCreate, objectCertificate, c.VsID, fmt.Sprintf("from secret %s", c.TLS.SecretName)))
+		ops = append(ops, newOperation(driver.OperationCreate, objectSSLPolicy, c.VsID, c.SSLPolicy.ToJson()))
+	}
+	ops = append(ops, newOperation(driver.OperationCreate, objectVirtualService, c.VirtualServerID, c.VirtualService.ToJson()))
+	return ops
+}
+
+func (c radwareConfig) planDelete() []driver.Operation {
+	ops := []driver.Operation{
+		newOperation(driver.OperationDelete, objectVirtualService, c.VirtualServerID, c.VirtualService.ToJson()),
+		newOperation(driver.OperationDelete, objectVirtualServer, c.VirtualServerID, "if no service left"),
+	}
+	if c.NetworkElements != nil {
+		ops = append(ops, newOperation(driver.OperationDelete, objectNetworkClass, c.VsID, ""))
+	}
+	if c.TLS != nil {
+		ops = append(ops, newOperation(driver.OperationDelete, objectSSLPolicy, c.VsID, ""))
+		ops = append(ops, newOperation(driver.OperationDelete, objectCertificate, c.VsID, ""))
+	}
+	ops = append(ops, newOperation(driver.OperationDelete, objectServerGroup, c.VsID, ""))
+	if c.HealthCheck != nil {
+		ops = append(ops, newOperation(driver.OperationDelete, objectHealthCheck, c.VsID, string(c.HealthCheckKind)))
+	}
+	for _, id := range sortedRealServerIDs(c.RealServers) {
+		ops = append(ops, newOperation(driver.OperationDelete, objectRealServer, id, ""))
+	}
+	return ops
+}
+
+func (c updateRadwareConfig) planUpdate() []driver.Operation {
+	ops := []driver.Operation{}
+	old, new := c.old, c.new
+	if new.HealthCheck != nil {
+		if old.HealthCheck == nil || old.HealthCheckKind != new.HealthCheckKind {
+			ops = append(ops, newOperation(driver.OperationCreate, objectHealthCheck, new.VsID, fmt.Sprintf("%s %s", new.HealthCheckKind, new.HealthCheck.ToJson())))
+		} else if *old.HealthCheck != *new.HealthCheck {
+			ops = append(ops, newOperation(driver.OperationUpdate, objectHealthCheck, new.VsID, fmt.Sprintf("%s %s", new.HealthCheckKind, new.HealthCheck.ToJson())))
+		}
+	}
+
+	if *old.ServerGroup != *new.ServerGroup {
+		ops = append(ops, newOperation(driver.OperationUpdate, objectServerGroup, new.VsID, new.ServerGroup.ToJson()))
+	}
+
+	if old.HealthCheck != nil && (new.HealthCheck == nil || old.HealthCheckKind != new.HealthCheckKind) {
+		ops = append(ops, newOperation(driver.OperationDelete, objectHealthCheck, old.VsID, string(old.HealthCheckKind)))
+	}
+
+	if isCertificateChanged(old, new) {
+		action := driver.OperationCreate
+		if old.TLS != nil {
+			action = driver.OperationUpdate
+		}
+		ops = append(ops, newOperation(action, objectCertificate, new.VsID, fmt.Sprintf("from secret %s", new.TLS.SecretName)))
+	}
+	if new.TLS != nil && old.TLS == nil {
+		ops = append(ops, newOperation(driver.OperationCreate, objectSSLPolicy, new.VsID, new.SSLPolicy.ToJson()))
+	}
+	if new.NetworkElements != nil && !reflect.DeepEqual(old.NetworkElements, new.NetworkElements) {
+		action := driver.OperationCreate
+		if old.NetworkElements != nil {
+			action = driver.OperationUpdate
+		}
+		ops = append(ops, newOperation(action, objectNetworkClass, new.VsID, strings.Join(getSourceRanges(new.NetworkElements), ",")))
+	}
+
+	if *old.VirtualService != *new.VirtualService || *old.RealGroup != *new.RealGroup {
+		ops = append(ops, newOperation(driver.OperationUpdate, objectVirtualService, new.VirtualServerID, fmt.Sprintf("%s %s", new.VirtualService.ToJson(), new.RealGroup.ToJson())))
+	}
+
+	if old.TLS != nil && new.TLS == nil {
+		ops = append(ops, newOperation(driver.OperationDelete, objectSSLPolicy, old.VsID, ""))
+		ops = append(ops, newOperation(driver.OperationDelete, objectCertificate, old.VsID, ""))
+	}
+	if old.NetworkElements != nil && new.NetworkElements == nil {
+		ops = append(ops, newOperation(driver.OperationDelete, objectNetworkClass, old.VsID, ""))
+	}
+
+	toUpdate := getToUpdateRsmap(old, new)
+	for _, id := range sortedRealServerIDs(toUpdate) {
+		ops = append(ops, newOperation(driver.OperationUpdate, objectRealServer, id, toUpdate[id].ToJson()))
+	}
+	ops = append(ops, planAddRealServers(new.VsID, getToAddRsmap(old, new), new.RealServerPort)...)
+	return ops
+}
+
+func (c updateRadwareConfig) planRemoveRealServers() []driver.Operation {
+	ops := []driver.Operation{}
+	for _, id := range sortedRealServerIDs(getToDeleteRsmap(c.old, c.new)) {
+		ops = append(ops, newOperation(driver.OperationUpdate, objectServerGroup, c.old.VsID, fmt.Sprintf("remove realserver %s", id)))
+		ops = append(ops, newOperation(driver.OperationDelete, objectRealServer, id, ""))
+	}
+	return ops
+}
+
+func (c updateRadwareConfig) planDrain(drainSeconds int32) []driver.Operation {
+	ops := []driver.Operation{}
+	for _, id := range sortedRealServerIDs(getToDeleteRsmap(c.old, c.new)) {
+		ops = append(ops, newOperation(driver.OperationUpdate, objectRealServer, id, fmt.Sprintf("disable and drain %vs", drainSeconds)))
+	}
+	return ops
+}
+
+func planAddRealServers(vsID string, rss map[string]*types.RealServer, port *types.RealServerPort) []driver.Operation {
+	ops := []driver.Operation{}
+	for _, id := range sortedRealServerIDs(rss) {
+		ops = append(ops, newOperation(driver.OperationCreate, objectRealServer, id, rss[id].ToJson()))
+		ops = append(ops, newOperation(driver.OperationCreate, objectRealServerPort, id, port.ToJson()))
+		ops = append(ops, newOperation(driver.OperationUpdate, objectServerGroup, vsID, fmt.Sprintf("add realserver %s", id)))
+	}
+	return ops
+}
+
+func sortedRealServerIDs(rss map[string]*types.RealServer) []string {
+	ids := make([]string, 0, len(rss))
+	for id := range rss {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func newOperation(action driver.OperationAction, object, id, detail string) driver.Operation {
+	return driver.Operation{
+		Action: action,
+		Object: object,
+		ID:     id,
+		Detail: detail,
+	}
+}
@@ -1,104 +1,265 @@
 package radware
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/zdnscloud/elb-controller/driver"
 	"github.com/zdnscloud/elb-controller/driver/radware/client"
 )
 
 const (
-	version = "radware lb driver v0.0.1"
+	version    = "radware lb driver v0.0.1"
+	DriverName = "radware"
+
+	MasterServerOption = "masterserver"
+	BackupServerOption = "backupserver"
+	UserOption         = "user"
+	PasswordOption     = "password"
 )
 
+func init() {
+	driver.Register(DriverName, NewFromOptions)
+}
+
 type RadwareDriver struct {
 	primary   *client.Client
 	secondary *client.Client
+	lock      *deviceLock
+	legacy    *legacyMigration
 }
 
 func New(masterServer, backupServer, user, password string) *RadwareDriver {
+	d := &RadwareDriver{
+		primary: client.New(user, password, masterServer),
+		lock:    newDeviceLock(),
+		legacy:  newLegacyMigration(),
+	}
 	if backupServer != "" {
-		return &RadwareDriver{
-			primary:   client.New(user, password, masterServer),
-			secondary: client.New(user, password, backupServer),
-		}
+		d.secondary = client.New(user, password, backupServer)
 	}
-	return &RadwareDriver{
-		primary: client.New(user, password, masterServer),
+	return d
+}
+
+func NewFromOptions(opts driver.Options) (driver.Driver, error) {
+	masterServer := opts.Get(MasterServerOption, "")
+	if masterServer == "" {
+		return nil, fmt.Errorf("radware driver option %s is required", MasterServerOption)
 	}
+	return New(masterServer, opts.Get(BackupServerOption, ""), opts.Get(UserOption, ""), opts.Get(PasswordOption, "")), nil
 }
 
-func (d *RadwareDriver) client() *client.Client {
+func (d *RadwareDriver) client(ctx context.Context) *client.Client {
 	if d.secondary == nil {
 		return d.primary
 	}
 
-	m, err := d.primary.IsMaster()
+	m, err := d.primary.IsMaster(ctx)
 	if m && err == nil {
 		return d.primary
 	}
 
-	b, err := d.secondary.IsMaster()
+	b, err := d.secondary.IsMaster(ctx)
 	if b && err == nil {
 		return d.secondary
 	}
 	return d.primary
 }
 
-func (d *RadwareDriver) Create(c driver.Config) error {
-	client := d.client()
+func (d *RadwareDriver) Create(ctx context.Context, c driver.Config) error {
+	client := d.client(ctx)
 	if err := validateConfig(c); err != nil {
 		return err
 	}
-	for _, config := range getRadwareConfigs(c) {
-		if err := config.create(client); err != nil {
+	if err := validateCertificates(c); err != nil {
+		return err
+	}
+
+	defer d.lock.lockVIPs(c.VIPs())()
+	if err := d.lock.build(ctx, func(ctx context.Context) error {
+		if err := d.legacy.migrate(ctx, client, c.K8sCluster, c.VIPs()); err != nil {
 			return err
 		}
+
+		for _, config := range getRadwareConfigs(c) {
+			if err := config.create(ctx, client); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
 	}
-	return client.ApplyAndSave()
+	return d.applyAndSave(ctx, client)
 }
 
-func (d *RadwareDriver) Update(old, new driver.Config) error {
-	client := d.client()
+func (d *RadwareDriver) Update(ctx context.Context, old, new driver.Config) error {
+	client := d.client(ctx)
 	if err := validateConfig(old); err != nil {
 		return err
 	}
 	if err := validateConfig(new); err != nil {
 		return err
 	}
+	if err := validateCertificates(new); err != nil {
+		return err
+	}
+
+	vips := append(old.VIPs(), new.VIPs()...)
+	drained, err := d.update(ctx, client, old, new)
+	if err != nil || len(drained) == 0 {
+		return err
+	}
+
+	// vip locks aren't held during waiting, so services sharing the vips aren't blocked
+	select {
+	case <-ctx.Done():
+		return driver.Errorf(driver.ErrorTransient, "drain realservers failed %s", ctx.Err().Error())
+	case <-time.After(time.Duration(new.DrainSeconds) * time.Second):
+	}
+	return d.removeDrained(ctx, vips, drained)
+}
 
+// update builds the whole update in one section, so other tasks never apply part
+// of it; with draining, the removed realservers are disabled after the new ones are
+// added, that complete state is applied and the updates with disabled realservers
+// are returned, they are removed by removeDrained after waiting
+func (d *RadwareDriver) update(ctx context.Context, cli *client.Client, old, new driver.Config) ([]updateRadwareConfig, error) {
+	defer d.lock.lockVIPs(append(old.VIPs(), new.VIPs()...))()
 	olds := getRadwareConfigs(old)
 	news := getRadwareConfigs(new)
-	for _, toD := range getToDeleteRdConfigs(olds, news) {
-		if err := toD.delete(client); err != nil {
+	updates := getUpdateRdConfigs(olds, news)
+	var drained bool
+	if err := d.lock.build(ctx, func(ctx context.Context) error {
+		if err := d.legacy.migrate(ctx, cli, new.K8sCluster, append(old.VIPs(), new.VIPs()...)); err != nil {
 			return err
 		}
-	}
 
-	for _, toA := range getToAddRdConfigs(olds, news) {
-		if err := toA.create(client); err != nil {
-			return err
+		for _, toD := range getToDeleteRdConfigs(olds, news) {
+			if err := toD.delete(ctx, cli); err != nil {
+				return err
+			}
 		}
+
+		for _, toA := range getToAddRdConfigs(olds, news) {
+			if err := toA.create(ctx, cli); err != nil {
+				return err
+			}
+		}
+
+		for _, toU := range updates {
+			if err := toU.update(ctx, cli); err != nil {
+				return err
+			}
+		}
+
+		if new.DrainSeconds > 0 {
+			for _, toU := range updates {
+				ok, err := toU.drain(ctx, cli)
+				if err != nil {
+					return err
+				}
+				drained = drained || ok
+			}
+		}
+		if drained {
+			return nil
+		}
+		return removeRealServers(ctx, cli, updates)
+	}); err != nil {
+		return nil, err
+	}
+
+	if drained {
+		return updates, d.lock.apply(func() error { return cli.Apply(ctx) })
+	}
+	return nil, d.applyAndSave(ctx, cli)
+}
+
+// removeDrained locks the vips again and removes the drained realservers from the
+// current master, the removal skips the objects which are already removed
+func (d *RadwareDriver) removeDrained(ctx context.Context, vips []string, updates []updateRadwareConfig) error {
+	defer d.lock.lockVIPs(vips)()
+	client := d.client(ctx)
+	if err := d.lock.build(ctx, func(ctx context.Context) error {
+		return removeRealServers(ctx, client, updates)
+	}); err != nil {
+		return err
 	}
+	return d.applyAndSave(ctx, client)
+}
 
-	for _, toU := range getUpdateRdConfigs(olds, news) {
-		if err := toU.update(client); err != nil {
+func removeRealServers(ctx context.Context, cli *client.Client, updates []updateRadwareConfig) error {
+	for _, toU := range updates {
+		if err := toU.removeRealServers(ctx, cli); err != nil {
 			return err
 		}
 	}
-	return d.client().ApplyAndSave()
+	return nil
 }
 
-func (d *RadwareDriver) Delete(c driver.Config) error {
-	client := d.client()
+func (d *RadwareDriver) Delete(ctx context.Context, c driver.Config) error {
+	client := d.client(ctx)
 	if err := validateConfig(c); err != nil {
 		return err
 	}
 
-	for _, config := range getRadwareConfigs(c) {
-		if err := config.delete(client); err != nil {
+	defer d.lock.lockVIPs(c.VIPs())()
+	if err := d.lock.build(ctx, func(ctx context.Context) error {
+		if err := d.legacy.migrate(ctx, client, c.K8sCluster, c.VIPs()); err != nil {
 			return err
 		}
+
+		for _, config := range getRadwareConfigs(c) {
+			if err := config.delete(ctx, client); err != nil {
+				return err
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return d.applyAndSave(ctx, client)
+}
+
+func (d *RadwareDriver) applyAndSave(ctx context.Context, cli *client.Client) error {
+	return d.lock.save(func() error { return cli.ApplyAndSave(ctx) })
+}
+
+func (d *RadwareDriver) Plan(ctx context.Context, old, new *driver.Config) ([]driver.Operation, error) {
+	for _, c := range []*driver.Config{old, new} {
+		if c == nil {
+			continue
+		}
+		if err := validateConfig(*c); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case old == nil && new == nil:
+		return []driver.Operation{}, nil
+	case old == nil:
+		return planCreate(getRadwareConfigs(*new)), nil
+	case new == nil:
+		return planDelete(getRadwareConfigs(*old)), nil
+	default:
+		return planUpdate(getRadwareConfigs(*old), getRadwareConfigs(*new), new.DrainSeconds), nil
+	}
+}
+
+func (d *RadwareDriver) Inventory(ctx context.Context, k8sCluster string) ([]driver.Config, error) {
+	return inventory(ctx, d.client(ctx), k8sCluster)
+}
+
+func (d *RadwareDriver) Capabilities() driver.Capabilities {
+	return driver.Capabilities{
+		Protocols:    []driver.Protocol{driver.ProtocolTCP, driver.ProtocolUDP, driver.ProtocolSCTP},
+		Methods:      []driver.LoadBalanceMethod{driver.LBMethodRoundRobin, driver.LBMethodLeastConnections, driver.LBMethodHash},
+		HealthChecks: []driver.HealthCheckType{driver.HealthCheckTCP, driver.HealthCheckUDP, driver.HealthCheckHTTP, driver.HealthCheckHTTPS, driver.HealthCheckICMP},
+		Features:     []driver.Feature{driver.FeatureIPv6, driver.FeatureClientIPPersistence, driver.FeatureTLSOffload, driver.FeatureSourceRanges, driver.FeaturePodBackend, driver.FeatureBackendDrain},
 	}
-	return client.ApplyAndSave()
 }
 
 func (d *RadwareDriver) Version() string {
@@ -5,17 +5,30 @@ import (
 )
 
 const (
+	IpVer6 = 2
+
+	RealServerStateEnabled  = 2
+	RealServerStateDisabled = 3
+
 	HaSwitchInfoStateMaster HaSwitchInfoState = "master"
 	HaSwitchInfoStateBackup HaSwitchInfoState = "backup"
 )
 
 type RealServer struct {
-	// IpAddr:realserver ip
-	IpAddr string `json:"IpAddr"`
-	// State:keep 2(enable)
+	// Index:realserver id, only returned by list
+	Index string `json:"Index,omitempty"`
+	// IpAddr:realserver ipv4 address
+	IpAddr string `json:"IpAddr,omitempty"`
+	// IpVer:2(ipv6), omitted for ipv4
+	IpVer int `json:"IpVer,omitempty"`
+	// Ipv6Addr:realserver ipv6 address
+	Ipv6Addr string `json:"Ipv6Addr,omitempty"`
+	// State:2(enable) 3(disable), disabled realserver doesn't accept new connections
 	State int `json:"State"`
 	// Type:keep 1(local)
 	Type int `json:"Type"`
+	// Weight:1-48, always sent so that a previous weight is reset
+	Weight int32 `json:"Weight"`
 }
 
 func (rs *RealServer) ToJson() string {
@@ -42,7 +55,10 @@ type RealServerPortList struct {
 }
 
 type ServerGroup struct {
-	Metric       int    `json:"Metric,omitempty"`
+	Index  string `json:"Index,omitempty"`
+	Metric int    `json:"Metric,omitempty"`
+	// IpVer:2(ipv6), omitted for ipv4
+	IpVer        int    `json:"IpVer,omitempty"`
 	HealthID     string `json:"HealthID,omitempty"`
 	AddServer    string `json:"AddServer,omitempty"`
 	RemoveServer string `json:"RemoveServer,omitempty"`
@@ -72,8 +88,12 @@ type GroupServerList struct {
 }
 
 type VirtualServer struct {
-	VirtServerIpAddress string `json:"VirtServerIpAddress"`
+	VirtServerIndex     string `json:"VirtServerIndex,omitempty"`
+	VirtServerIpAddress string `json:"VirtServerIpAddress,omitempty"`
 	VirtServerState     int    `json:"VirtServerState"`
+	// VirtServerIpVer:2(ipv6), omitted for ipv4
+	VirtServerIpVer    int    `json:"VirtServerIpVer,omitempty"`
+	VirtServerIpv6Addr string `json:"VirtServerIpv6Addr,omitempty"`
 }
 
 func (v *VirtualServer) ToJson() string {
@@ -86,11 +106,24 @@ type VirtualServerList struct {
 }
 
 type VirtualService struct {
+	// VirtServIndex:virtual server id, only returned by list
+	VirtServIndex string `json:"VirtServIndex,omitempty"`
+	// Index:service index in the virtual server, only returned by list
+	Index int `json:"Index,omitempty"`
+	// UDPBalance:service protocol, 2(udp) 3(tcp) 6(sctp)
 	UDPBalance int32 `json:"UDPBalance"`
 	VirtPort   int32 `json:"VirtPort"`
 	RealPort   int32 `json:"RealPort"`
-	DBind      int   `json:"DBind"`
-	PBind      int   `json:"PBind"`
+	// DBind:delayed binding, 2(enable) 3(forceproxy, required by ssl offload)
+	DBind int `json:"DBind"`
+	// PBind:persistent binding, 2(clientip) 3(disable)
+	PBind int `json:"PBind"`
+	// SSLpol:ssl policy id, empty means no ssl offload, it is always sent so that disabling ssl offload clears it
+	SSLpol string `json:"SSLpol"`
+	// ServCert:server certificate id used by ssl offload
+	ServCert string `json:"ServCert"`
+	// SrcNetwork:network class id of allowed client addresses, empty means all
+	SrcNetwork string `json:"SrcNetwork"`
 }
 
 func (v *VirtualService) ToJson() string {
@@ -129,14 +162,112 @@ func NewRemoveServerServerGroup(id string) *ServerGroup {
 	}
 }
 
-func NewVirtualServiceRealGroup(id string) *VirtualServiceRealGroup {
+// NewVirtualServiceRealGroup persistentTimeOut is in minutes
+func NewVirtualServiceRealGroup(id string, persistentTimeOut int) *VirtualServiceRealGroup {
 	return &VirtualServiceRealGroup{
 		RealGroup:         id,
-		PersistentTimeOut: 10,
+		PersistentTimeOut: persistentTimeOut,
 		ProxyIpMode:       1,
 	}
 }
 
+type HealthCheckKind string
+
+const (
+	HealthCheckKindTcp  HealthCheckKind = "tcp"
+	HealthCheckKindUdp  HealthCheckKind = "udp"
+	HealthCheckKindHttp HealthCheckKind = "http"
+	HealthCheckKindIcmp HealthCheckKind = "icmp"
+)
+
+// HealthCheck is an advanced health check object, the table it belongs to is decided by HealthCheckKind
+type HealthCheck struct {
+	// DPort:destination port, 0 means use realserver port
+	DPort    int32 `json:"DPort,omitempty"`
+	Interval int32 `json:"Interval,omitempty"`
+	Timeout  int32 `json:"Timeout,omitempty"`
+	Retries  int32 `json:"Retries,omitempty"`
+	// Https:1(enable) 2(disable), only for http health check
+	Https int    `json:"Https,omitempty"`
+	Path  string `json:"Path,omitempty"`
+	// RCode:expected http return code
+	RCode string `json:"RCode,omitempty"`
+}
+
+func (h *HealthCheck) ToJson() string {
+	b, _ := json.Marshal(h)
+	return string(b)
+}
+
+type SSLPolicy struct {
+	// FESsl:frontend ssl, 1(enable) 2(disable)
+	FESsl int `json:"FESsl"`
+	// BESsl:backend ssl, 1(enable) 2(disable)
+	BESsl int `json:"BESsl"`
+	// AdminStatus:1(enable) 2(disable)
+	AdminStatus int `json:"AdminStatus"`
+}
+
+func (p *SSLPolicy) ToJson() string {
+	b, _ := json.Marshal(p)
+	return string(b)
+}
+
+type SSLPolicyList struct {
+	PolicyTable []SSLPolicy `json:"SlbNewSslCfgSSLPolTable"`
+}
+
+// NewOffloadSSLPolicy terminates tls on the device and forwards plain traffic to realservers
+func NewOffloadSSLPolicy() *SSLPolicy {
+	return &SSLPolicy{
+		FESsl:       1,
+		BESsl:       2,
+		AdminStatus: 1,
+	}
+}
+
+type Certificate struct {
+	ID   string `json:"ID,omitempty"`
+	Name string `json:"Name,omitempty"`
+}
+
+type CertificateList struct {
+	CertTable []Certificate `json:"SlbNewSslCfgCertsSrvrCertTable"`
+}
+
+type NetworkClass struct {
+	Name string `json:"Name"`
+}
+
+type NetworkClassList struct {
+	NCTable []NetworkClass `json:"SlbNewCfgNetworkClassTable"`
+}
+
+type NetworkElement struct {
+	// NetElemIndex:element id in network class, only returned by list
+	NetElemIndex string `json:"NetElemIndex,omitempty"`
+	// NetType:2(subnet)
+	NetType int `json:"NetType"`
+	// MatchType:1(include)
+	MatchType int `json:"MatchType"`
+	// IpVer:2(ipv6), omitted for ipv4
+	IpVer int    `json:"IpVer,omitempty"`
+	Net   string `json:"Net"`
+	// Mask:ipv4 subnet mask
+	Mask string `json:"Mask,omitempty"`
+	// Prefix:ipv6 prefix length
+	Prefix int `json:"Prefix,omitempty"`
+}
+
+func (e *NetworkElement) ToJson() string {
+	b, _ := json.Marshal(e)
+	return string(b)
+}
+
+type NetworkElementList struct {
+	NETable []NetworkElement `json:"SlbNewCfgNwclssNetworkElemTable"`
+}
+
 type HaState struct {
 	HaSwitchInfoState HaSwitchInfoState `json:"haSwitchInfoState"`
 }
@@ -11,23 +11,25 @@ const maxIDLength = 255
 
 func validateConfig(c driver.Config) error {
 	if err := validateConfigOption(c); err != nil {
-		return fmt.Errorf("driver config validate failed %s", err.Error())
+		return driver.Errorf(driver.ErrorInvalidConfig, "driver config validate failed %s", err.Error())
 	}
 	if err := validateGenIdLength(c); err != nil {
-		return fmt.Errorf("driver config validate failed %s", err.Error())
+		return driver.Errorf(driver.ErrorInvalidConfig, "driver config validate failed %s", err.Error())
 	}
 	return nil
 }
 
 func validateGenIdLength(c driver.Config) error {
-	for _, s := range c.Services {
-		vsID := genVsID(s, c)
-		if len(vsID) > maxIDLength {
-			return fmt.Errorf("gen vsid %s exceed max id length %v", vsID, maxIDLength)
-		}
-		for rsID := range getRsmap(c, s) {
-			if len(rsID) > maxIDLength {
-				return fmt.Errorf("gen rsid %s exceed max id length %v", rsID, maxIDLength)
+	for _, vip := range c.VIPs() {
+		for _, s := range c.Services {
+			vsID := genVsID(vip, s, c)
+			if len(vsID) > maxIDLength {
+				return fmt.Errorf("gen vsid %s exceed max id length %v", vsID, maxIDLength)
+			}
+			for rsID := range getRsmap(c, s, vip) {
+				if len(rsID) > maxIDLength {
+					return fmt.Errorf("gen rsid %s exceed max id length %v", rsID, maxIDLength)
+				}
 			}
 		}
 	}
@@ -44,12 +46,59 @@ func validateConfigOption(c driver.Config) error {
 	if c.K8sService == "" {
 		return fmt.Errorf("K8sService field empty")
 	}
-	if !isIPv4(c.VIP) {
+	if c.VIP == "" && c.VIPv6 == "" {
+		return fmt.Errorf("VIP and VIPv6 fields are both empty")
+	}
+	if c.VIP != "" && !isIPv4(c.VIP) {
 		return fmt.Errorf("VIP field %s isn't an ipv4 address", c.VIP)
 	}
+	if c.VIPv6 != "" && !isIPv6(c.VIPv6) {
+		return fmt.Errorf("VIPv6 field %s isn't an ipv6 address", c.VIPv6)
+	}
+	if c.Persistence != nil && c.Persistence.Type != driver.PersistenceNone && c.Persistence.Type != driver.PersistenceClientIP {
+		return fmt.Errorf("persistence type %s isn't supported", c.Persistence.Type)
+	}
+	if err := validateSourceRanges(c); err != nil {
+		return err
+	}
+	if c.DrainSeconds < 0 {
+		return fmt.Errorf("drain seconds %v shouldn't be negative", c.DrainSeconds)
+	}
+	switch c.BackendMode {
+	case "", driver.BackendModeNode, driver.BackendModePod:
+	default:
+		return fmt.Errorf("backend mode %s isn't supported", c.BackendMode)
+	}
 	return validateConfigServices(c)
 }
 
+// validateSourceRanges checks every vip has source ranges of its address family,
+// otherwise all clients of the vip would be allowed instead of none
+func validateSourceRanges(c driver.Config) error {
+	if len(c.SourceRanges) == 0 {
+		return nil
+	}
+	var hasV4, hasV6 bool
+	for _, r := range c.SourceRanges {
+		ip, _, err := net.ParseCIDR(r)
+		if err != nil {
+			return fmt.Errorf("source range %s isn't a cidr", r)
+		}
+		if ip.To4() != nil {
+			hasV4 = true
+		} else {
+			hasV6 = true
+		}
+	}
+	if c.VIP != "" && !hasV4 {
+		return fmt.Errorf("source ranges have no ipv4 cidr for VIP %s", c.VIP)
+	}
+	if c.VIPv6 != "" && !hasV6 {
+		return fmt.Errorf("source ranges have no ipv6 cidr for VIPv6 %s", c.VIPv6)
+	}
+	return nil
+}
+
 func validateConfigServices(c driver.Config) error {
 	if len(c.Services) == 0 {
 		return nil
@@ -74,8 +123,53 @@ func validateConfigService(s driver.Service) error {
 			return fmt.Errorf("service backendhost %s isn't an ipv4 address", h)
 		}
 	}
-	if s.Protocol == "" {
+	for _, h := range s.BackendHostsV6 {
+		if !isIPv6(h) {
+			return fmt.Errorf("service backendhostv6 %s isn't an ipv6 address", h)
+		}
+	}
+	switch s.Protocol {
+	case driver.ProtocolTCP, driver.ProtocolUDP, driver.ProtocolSCTP:
+	case "":
 		return fmt.Errorf("service Protocol is empty")
+	default:
+		return fmt.Errorf("service Protocol %s isn't supported", s.Protocol)
+	}
+	if s.TLS != nil && s.Protocol != driver.ProtocolTCP {
+		return fmt.Errorf("service port %v tls offload is only for tcp", s.Port)
+	}
+	return validateHealthCheck(s.HealthCheck)
+}
+
+// validateCertificates checks the certificates to be imported, it isn't needed
+// by delete config whose secret may be deleted already
+func validateCertificates(c driver.Config) error {
+	for _, s := range c.Services {
+		if s.TLS != nil && (s.TLS.Certificate == "" || s.TLS.Key == "") {
+			return driver.Errorf(driver.ErrorInvalidConfig, "driver config validate failed service port %v tls secret %s has no certificate or key", s.Port, s.TLS.SecretName)
+		}
+	}
+	return nil
+}
+
+func validateHealthCheck(hc *driver.HealthCheck) error {
+	if hc == nil {
+		return nil
+	}
+	switch hc.Type {
+	case driver.HealthCheckTCP, driver.HealthCheckUDP, driver.HealthCheckICMP:
+		if hc.Path != "" || hc.ExpectedStatus != 0 {
+			return fmt.Errorf("healthcheck path and expected status are only for http and https healthcheck")
+		}
+	case driver.HealthCheckHTTP, driver.HealthCheckHTTPS:
+		if hc.ExpectedStatus != 0 && (hc.ExpectedStatus < 100 || hc.ExpectedStatus > 599) {
+			return fmt.Errorf("healthcheck expected status %v isn't legal", hc.ExpectedStatus)
+		}
+	default:
+		return fmt.Errorf("healthcheck type %s isn't supported", hc.Type)
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.Retries < 0 {
+		return fmt.Errorf("healthcheck interval, timeout and retries shouldn't be negative")
 	}
 	return nil
 }
@@ -85,6 +179,11 @@ func isIPv4(input string) bool {
 	return ip != nil && ip.To4() != nil
 }
 
+func isIPv6(input string) bool {
+	ip := net.ParseIP(input)
+	return ip != nil && ip.To4() == nil
+}
+
 func isPort(p int32) bool {
 	return p > 0 && p <= 65535
 }
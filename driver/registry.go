@@ -0,0 +1,74 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Options is the driver specific options, it implements flag.Value so that
+// it can be filled by a repeated "key=value" command line flag
+type Options map[string]string
+
+type Factory func(Options) (Driver, error)
+
+var (
+	factories    = make(map[string]Factory)
+	factoriesMux sync.Mutex
+)
+
+func Register(name string, factory Factory) {
+	factoriesMux.Lock()
+	defer factoriesMux.Unlock()
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("driver %s registered twice", name))
+	}
+	factories[name] = factory
+}
+
+func New(name string, opts Options) (Driver, error) {
+	factoriesMux.Lock()
+	factory, ok := factories[name]
+	factoriesMux.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown driver %s, registered drivers %v", name, Drivers())
+	}
+	return factory(opts)
+}
+
+func Drivers() []string {
+	factoriesMux.Lock()
+	defer factoriesMux.Unlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (o Options) String() string {
+	kvs := make([]string, 0, len(o))
+	for k, v := range o {
+		kvs = append(kvs, k+"="+v)
+	}
+	sort.Strings(kvs)
+	return strings.Join(kvs, ",")
+}
+
+func (o Options) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("driver option %s should be key=value", value)
+	}
+	o[kv[0]] = kv[1]
+	return nil
+}
+
+func (o Options) Get(key, defaultValue string) string {
+	if v, ok := o[key]; ok {
+		return v
+	}
+	return defaultValue
+}
@@ -0,0 +1,87 @@
+package testdriver
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+)
+
+// ServeDebug serves the debug endpoint on addr in background, GET /configs returns
+// programmed configs without private keys, GET /failures returns the failure script,
+// POST /failures appends a failure and DELETE /failures clears them
+func (d *TestDriver) ServeDebug(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: d.DebugHandler()}
+	d.lock.Lock()
+	d.server = server
+	d.lock.Unlock()
+	go func() {
+		if err := server.Serve(l); err != nil && err != http.ErrServerClosed {
+			d.logger.Warn("[TestDriver] debug server stopped %s", err.Error())
+		}
+	}()
+	return nil
+}
+
+func (d *TestDriver) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/configs", d.handleConfigs)
+	mux.HandleFunc("/failures", d.handleFailures)
+	return mux
+}
+
+func (d *TestDriver) Close() error {
+	d.lock.Lock()
+	server := d.server
+	d.server = nil
+	d.lock.Unlock()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(context.Background())
+}
+
+func (d *TestDriver) handleConfigs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	configs := d.Configs()
+	for i := range configs {
+		configs[i] = configs[i].Redact()
+	}
+	writeJson(w, configs)
+}
+
+func (d *TestDriver) handleFailures(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJson(w, d.Failures())
+	case http.MethodPost:
+		var f Failure
+		if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if f.Action == "" || f.Type == "" {
+			http.Error(w, "failure action and type are required", http.StatusBadRequest)
+			return
+		}
+		d.InjectFailure(f)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		d.ClearFailures()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
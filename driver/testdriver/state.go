@@ -0,0 +1,162 @@
+package testdriver
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/zdnscloud/elb-controller/driver"
+)
+
+type Action string
+
+const (
+	ActionCreate    Action = "create"
+	ActionUpdate    Action = "update"
+	ActionDelete    Action = "delete"
+	ActionPlan      Action = "plan"
+	ActionInventory Action = "inventory"
+)
+
+// Failure makes the matched calls return an error of Type instead of being applied
+type Failure struct {
+	Action Action `json:"action"`
+	// K8sService is namespace/name of the service, empty matches every call
+	K8sService string           `json:"k8sService,omitempty"`
+	Type       driver.ErrorType `json:"type"`
+	Message    string           `json:"message,omitempty"`
+	// Times is how many calls fail, 0 means every call fails until failures are cleared
+	Times int `json:"times,omitempty"`
+}
+
+// InjectFailure appends f to the failure script, the first matched failure is used
+func (d *TestDriver) InjectFailure(f Failure) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.failures = append(d.failures, &f)
+}
+
+func (d *TestDriver) ClearFailures() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.failures = nil
+}
+
+func (d *TestDriver) Failures() []Failure {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	failures := make([]Failure, 0, len(d.failures))
+	for _, f := range d.failures {
+		failures = append(failures, *f)
+	}
+	return failures
+}
+
+// Get returns the programmed config of the k8s service
+func (d *TestDriver) Get(k8sCluster, k8sNamespace, k8sService string) (driver.Config, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	c, ok := d.configs[genConfigKey(k8sCluster, k8sNamespace, k8sService)]
+	if !ok {
+		return driver.Config{}, false
+	}
+	return copyConfig(c), true
+}
+
+// Configs returns all programmed configs sorted by cluster, namespace and name
+func (d *TestDriver) Configs() []driver.Config {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.sortedConfigs()
+}
+
+// Calls returns how many times action is called, including failed calls
+func (d *TestDriver) Calls(action Action) int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.calls[action]
+}
+
+// Reset removes all configs, failures and call counts
+func (d *TestDriver) Reset() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.configs = make(map[string]driver.Config)
+	d.calls = make(map[Action]int)
+	d.failures = nil
+}
+
+// before counts the call and returns the injected failure, c is nil for inventory
+func (d *TestDriver) before(action Action, c *driver.Config) error {
+	d.calls[action] += 1
+	for i, f := range d.failures {
+		if f.Action != action {
+			continue
+		}
+		if f.K8sService != "" && (c == nil || f.K8sService != c.K8sNamespace+"/"+c.K8sService) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times -= 1
+			if f.Times == 0 {
+				d.failures = append(d.failures[:i], d.failures[i+1:]...)
+			}
+		}
+		msg := f.Message
+		if msg == "" {
+			msg = "injected failure"
+		}
+		return driver.Errorf(f.Type, "%s %s", action, msg)
+	}
+	return nil
+}
+
+// checkConflict returns error if any vip, protocol and port of c is used by other service
+func (d *TestDriver) checkConflict(c driver.Config) error {
+	if len(c.VIPs()) == 0 {
+		return driver.Errorf(driver.ErrorInvalidConfig, "config of %s has no vip", configKey(c))
+	}
+	ports := getServices(&c)
+	for key, other := range d.configs {
+		if key == configKey(c) {
+			continue
+		}
+		for _, vip := range other.VIPs() {
+			for _, s := range other.Services {
+				if _, ok := ports[fmt.Sprintf("%s/%s/%s/%s/%v", c.K8sNamespace, c.K8sService, vip, s.Protocol, s.Port)]; ok {
+					return driver.Errorf(driver.ErrorConflict, "%s %s port %v is used by %s", vip, s.Protocol, s.Port, key)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (d *TestDriver) sortedConfigs() []driver.Config {
+	keys := make([]string, 0, len(d.configs))
+	for k := range d.configs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	configs := make([]driver.Config, 0, len(keys))
+	for _, k := range keys {
+		configs = append(configs, copyConfig(d.configs[k]))
+	}
+	return configs
+}
+
+func configKey(c driver.Config) string {
+	return genConfigKey(c.K8sCluster, c.K8sNamespace, c.K8sService)
+}
+
+func genConfigKey(k8sCluster, k8sNamespace, k8sService string) string {
+	return k8sCluster + "/" + k8sNamespace + "/" + k8sService
+}
+
+// copyConfig deep copies c so that callers can't modify the model
+func copyConfig(c driver.Config) driver.Config {
+	var result driver.Config
+	b, _ := json.Marshal(c)
+	json.Unmarshal(b, &result)
+	return result
+}
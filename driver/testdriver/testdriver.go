@@ -1,41 +1,210 @@
 package testdriver
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+
 	"github.com/zdnscloud/cement/log"
 	"github.com/zdnscloud/elb-controller/driver"
 )
 
 const (
 	versionInfo = "zcloud lb test driver"
+	DriverName  = "test"
+
+	// DebugAddrOption is the listen address of the debug http endpoint, empty means disabled
+	DebugAddrOption = "test-debug-addr"
 )
 
+func init() {
+	driver.Register(DriverName, NewFromOptions)
+}
+
+// TestDriver keeps an in-memory model of the configs programmed on a fake
+// loadbalancer, it's used to verify controller behaviour without a device
 type TestDriver struct {
-	serverAddr string
-	user       string
-	password   string
+	lock     sync.Mutex
+	configs  map[string]driver.Config
+	failures []*Failure
+	calls    map[Action]int
+	server   *http.Server
+	logger   log.Logger
 }
 
+// NewFromOptions is used by the controller, the driver logs to the global logger
+func NewFromOptions(opts driver.Options) (driver.Driver, error) {
+	d := New()
+	d.logger = globalLogger{}
+	if addr := opts.Get(DebugAddrOption, ""); addr != "" {
+		if err := d.ServeDebug(addr); err != nil {
+			return nil, fmt.Errorf("test driver option %s is invalid %s", DebugAddrOption, err.Error())
+		}
+	}
+	return d, nil
+}
+
+// New returns a driver without logging, so it can be used in tests which don't
+// initialize the global logger
 func New() *TestDriver {
-	return &TestDriver{}
+	return &TestDriver{
+		configs: make(map[string]driver.Config),
+		calls:   make(map[Action]int),
+		logger:  log.NewBlackHole(),
+	}
 }
 
-func (d *TestDriver) Create(c driver.Config) error {
-	log.Debugf("[TestDriver] recvice create task:%s", c.ToJson())
+// Create is idempotent, creating an existing config replaces it
+func (d *TestDriver) Create(ctx context.Context, c driver.Config) error {
+	d.logger.Debug("[TestDriver] recvice create task:%s", c.Redact().ToJson())
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.before(ActionCreate, &c); err != nil {
+		return err
+	}
+	if err := d.checkConflict(c); err != nil {
+		return err
+	}
+	d.configs[configKey(c)] = copyConfig(c)
 	return nil
 }
 
-func (d *TestDriver) Update(old, new driver.Config) error {
-	log.Debugf("[TestDriver] recvice update task:%s %s", old.ToJson(), new.ToJson())
+func (d *TestDriver) Update(ctx context.Context, old, new driver.Config) error {
+	d.logger.Debug("[TestDriver] recvice update task:%s %s", old.Redact().ToJson(), new.Redact().ToJson())
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.before(ActionUpdate, &new); err != nil {
+		return err
+	}
+	if _, ok := d.configs[configKey(old)]; !ok {
+		return driver.Errorf(driver.ErrorNotFound, "config of %s doesn't exist", configKey(old))
+	}
+	if err := d.checkConflict(new); err != nil {
+		return err
+	}
+	delete(d.configs, configKey(old))
+	d.configs[configKey(new)] = copyConfig(new)
 	return nil
 }
 
-func (d *TestDriver) Delete(c driver.Config) error {
-	log.Debugf("[TestDriver] recvice delete task:%s", c.ToJson())
+func (d *TestDriver) Delete(ctx context.Context, c driver.Config) error {
+	d.logger.Debug("[TestDriver] recvice delete task:%s", c.Redact().ToJson())
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.before(ActionDelete, &c); err != nil {
+		return err
+	}
+	if _, ok := d.configs[configKey(c)]; !ok {
+		return driver.Errorf(driver.ErrorNotFound, "config of %s doesn't exist", configKey(c))
+	}
+	delete(d.configs, configKey(c))
 	return nil
 }
 
+// Plan plans an operation for each vip and port
+func (d *TestDriver) Plan(ctx context.Context, old, new *driver.Config) ([]driver.Operation, error) {
+	olds, news := getServices(old), getServices(new)
+	ops := []driver.Operation{}
+	for _, id := range sortedKeys(olds) {
+		if _, ok := news[id]; !ok {
+			ops = append(ops, driver.Operation{Action: driver.OperationDelete, Object: "service", ID: id})
+		}
+	}
+	for _, id := range sortedKeys(news) {
+		o, ok := olds[id]
+		if !ok {
+			ops = append(ops, driver.Operation{Action: driver.OperationCreate, Object: "service", ID: id})
+		} else if !reflect.DeepEqual(o, news[id]) || old.Method != new.Method || !reflect.DeepEqual(old.Persistence, new.Persistence) {
+			ops = append(ops, driver.Operation{Action: driver.OperationUpdate, Object: "service", ID: id})
+		}
+	}
+	d.logger.Debug("[TestDriver] recvice plan task:%v", ops)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	c := new
+	if c == nil {
+		c = old
+	}
+	if err := d.before(ActionPlan, c); err != nil {
+		return nil, err
+	}
+	return ops, nil
+}
+
+func getServices(c *driver.Config) map[string]driver.Service {
+	services := make(map[string]driver.Service)
+	if c == nil {
+		return services
+	}
+	for _, vip := range c.VIPs() {
+		for _, s := range c.Services {
+			services[fmt.Sprintf("%s/%s/%s/%s/%v", c.K8sNamespace, c.K8sService, vip, s.Protocol, s.Port)] = s
+		}
+	}
+	return services
+}
+
+func sortedKeys(services map[string]driver.Service) []string {
+	keys := make([]string, 0, len(services))
+	for k := range services {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (d *TestDriver) Inventory(ctx context.Context, k8sCluster string) ([]driver.Config, error) {
+	d.logger.Debug("[TestDriver] recvice inventory task:%s", k8sCluster)
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err := d.before(ActionInventory, nil); err != nil {
+		return nil, err
+	}
+	configs := []driver.Config{}
+	for _, c := range d.sortedConfigs() {
+		if c.K8sCluster == k8sCluster {
+			configs = append(configs, c)
+		}
+	}
+	return configs, nil
+}
+
+func (d *TestDriver) Capabilities() driver.Capabilities {
+	return driver.Capabilities{
+		Protocols:    []driver.Protocol{driver.ProtocolTCP, driver.ProtocolUDP, driver.ProtocolSCTP},
+		Methods:      []driver.LoadBalanceMethod{driver.LBMethodRoundRobin, driver.LBMethodLeastConnections, driver.LBMethodHash},
+		HealthChecks: []driver.HealthCheckType{driver.HealthCheckTCP, driver.HealthCheckUDP, driver.HealthCheckHTTP, driver.HealthCheckHTTPS, driver.HealthCheckICMP},
+		Features:     []driver.Feature{driver.FeatureIPv6, driver.FeatureClientIPPersistence, driver.FeatureCookiePersistence, driver.FeatureTLSOffload, driver.FeatureSourceRanges, driver.FeaturePodBackend, driver.FeatureBackendDrain},
+	}
+}
+
 func (d *TestDriver) Version() string {
 	return versionInfo
 }
 
+// globalLogger forwards to the global logger which is initialized by the controller
+type globalLogger struct{}
+
+func (globalLogger) Debug(fmt string, args ...interface{}) {
+	log.Debugf(fmt, args...)
+}
+
+func (globalLogger) Info(fmt string, args ...interface{}) {
+	log.Infof(fmt, args...)
+}
+
+func (globalLogger) Warn(fmt string, args ...interface{}) error {
+	return log.Warnf(fmt, args...)
+}
+
+func (globalLogger) Error(fmt string, args ...interface{}) error {
+	return log.Errorf(fmt, args...)
+}
+
+func (globalLogger) Close() {
+}
+
 var _ driver.Driver = &TestDriver{}
@@ -0,0 +1,152 @@
+package testdriver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zdnscloud/elb-controller/driver"
+	"github.com/zdnscloud/elb-controller/driver/drivertest"
+)
+
+func testConfig(name string) driver.Config {
+	return driver.Config{
+		K8sCluster:   "cluster",
+		K8sNamespace: "default",
+		K8sService:   name,
+		VIP:          "192.0.2.10",
+		Method:       driver.LBMethodRoundRobin,
+		Services: []driver.Service{
+			{
+				Port:         80,
+				BackendPort:  30080,
+				BackendHosts: []string{"198.51.100.1"},
+				Protocol:     driver.ProtocolTCP,
+			},
+		},
+	}
+}
+
+func TestInjectFailureTimes(t *testing.T) {
+	d := New()
+	ctx := context.Background()
+	d.InjectFailure(Failure{Action: ActionCreate, K8sService: "default/web", Type: driver.ErrorTransient, Times: 2})
+
+	for i := 0; i < 2; i++ {
+		err := d.Create(ctx, testConfig("web"))
+		if driver.ErrorTypeOf(err) != driver.ErrorTransient {
+			t.Fatalf("create %v should fail with transient error but got %v", i, err)
+		}
+	}
+	if err := d.Create(ctx, testConfig("web")); err != nil {
+		t.Fatalf("create after injected failures should succeed but got %s", err.Error())
+	}
+	if _, ok := d.Get("cluster", "default", "web"); !ok {
+		t.Fatal("config should be programmed")
+	}
+	if len(d.Failures()) != 0 {
+		t.Fatalf("failure should be removed after used up but got %v", d.Failures())
+	}
+	if d.Calls(ActionCreate) != 3 {
+		t.Fatalf("create calls should be 3 but got %v", d.Calls(ActionCreate))
+	}
+}
+
+func TestInjectFailureMatchService(t *testing.T) {
+	d := New()
+	ctx := context.Background()
+	d.InjectFailure(Failure{Action: ActionDelete, K8sService: "default/web", Type: driver.ErrorAuthFailure})
+
+	for _, name := range []string{"web", "db"} {
+		c := testConfig(name)
+		c.Services[0].Port = map[string]int32{"web": 80, "db": 3306}[name]
+		if err := d.Create(ctx, c); err != nil {
+			t.Fatalf("create %s failed %s", name, err.Error())
+		}
+	}
+	if err := d.Delete(ctx, testConfig("web")); driver.ErrorTypeOf(err) != driver.ErrorAuthFailure {
+		t.Fatalf("delete web should fail with auth failure but got %v", err)
+	}
+	db := testConfig("db")
+	db.Services[0].Port = 3306
+	if err := d.Delete(ctx, db); err != nil {
+		t.Fatalf("delete db should succeed but got %s", err.Error())
+	}
+	// failure without times is kept until cleared
+	if err := d.Delete(ctx, testConfig("web")); driver.ErrorTypeOf(err) != driver.ErrorAuthFailure {
+		t.Fatalf("delete web should still fail but got %v", err)
+	}
+	d.ClearFailures()
+	if err := d.Delete(ctx, testConfig("web")); err != nil {
+		t.Fatalf("delete web after failures cleared should succeed but got %s", err.Error())
+	}
+}
+
+func TestDebugHandler(t *testing.T) {
+	d := New()
+	server := httptest.NewServer(d.DebugHandler())
+	defer server.Close()
+
+	c := testConfig("web")
+	c.Services[0].TLS = &driver.TLS{SecretName: "web-tls", Certificate: "cert", Key: "key"}
+	if err := d.Create(context.Background(), c); err != nil {
+		t.Fatalf("create failed %s", err.Error())
+	}
+	resp, err := http.Get(server.URL + "/configs")
+	if err != nil {
+		t.Fatalf("get configs failed %s", err.Error())
+	}
+	var configs []driver.Config
+	err = json.NewDecoder(resp.Body).Decode(&configs)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("decode configs failed %s", err.Error())
+	}
+	if len(configs) != 1 || configs[0].K8sService != "web" {
+		t.Fatalf("configs should only have web but got %v", configs)
+	}
+	if configs[0].Services[0].TLS.Key == "key" {
+		t.Fatal("private key shouldn't be returned")
+	}
+
+	body, _ := json.Marshal(Failure{Action: ActionUpdate, Type: driver.ErrorConflict, Times: 1})
+	resp, err = http.Post(server.URL+"/failures", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("post failure failed %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("post failure should return %v but got %v", http.StatusCreated, resp.StatusCode)
+	}
+	if err := d.Update(context.Background(), c, c); driver.ErrorTypeOf(err) != driver.ErrorConflict {
+		t.Fatalf("update should fail with conflict but got %v", err)
+	}
+
+	resp, err = http.Post(server.URL+"/failures", "application/json", bytes.NewReader([]byte(`{"action":"update"}`)))
+	if err != nil {
+		t.Fatalf("post failure failed %s", err.Error())
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("failure without type should be rejected but got %v", resp.StatusCode)
+	}
+
+	d.InjectFailure(Failure{Action: ActionInventory, Type: driver.ErrorTransient})
+	req, _ := http.NewRequest(http.MethodDelete, server.URL+"/failures", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("delete failures failed %s", err.Error())
+	}
+	resp.Body.Close()
+	if len(d.Failures()) != 0 {
+		t.Fatalf("failures should be cleared but got %v", d.Failures())
+	}
+}
+
+func TestConformance(t *testing.T) {
+	d := New()
+	drivertest.Run(t, d, drivertest.InventoryInspector(d))
+}
@@ -0,0 +1,24 @@
+package lbctrl
+
+import (
+	"math/rand"
+	"time"
+)
+
+const (
+	retryBaseDelay       = 2 * time.Second
+	DefaultMaxRetryDelay = 5 * time.Minute
+)
+
+// retryDelay doubles with each failure up to maxDelay, the jitter spreads
+// retries of services which failed together, like when the device is down
+func retryDelay(failures int, maxDelay time.Duration) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < failures && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/zdnscloud/elb-controller/driver"
 
@@ -18,6 +20,7 @@ import (
 	"github.com/zdnscloud/gok8s/predicate"
 	"github.com/zdnscloud/gok8s/recorder"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -25,8 +28,10 @@ import (
 )
 
 const (
-	taskBufferCount = 30
-	maxTaskFailures = 5
+	// service whose task fails maxTaskFailures times is parked, it's retried every max retry delay
+	maxTaskFailures    = 5
+	DefaultTaskTimeout = 3 * time.Minute
+	DefaultWorkers     = 4
 
 	ElbControllerName        = "elb-controller"
 	ZcloudLBServiceFinalizer = "lb.zcloud.cn/protect"
@@ -34,24 +39,55 @@ const (
 	CreateLBConfigFailedReason = "CreateLBConfigFailed"
 	UpdateLBConfigFailedReason = "UpdateLBConfigFailed"
 	DeleteLBConfigFailedReason = "DeleteLBConfigFailed"
+	InvalidLBConfigReason      = "InvalidLBConfig"
+	LBAuthFailedReason         = "LBAuthFailed"
+	GetTLSSecretFailedReason   = "GetTLSSecretFailed"
+	LBConfigPlannedReason      = "LBConfigPlanned"
+	PlanLBConfigFailedReason   = "PlanLBConfigFailed"
+	VIPConflictReason          = "VIPConflict"
+	ResyncLBConfigFailedReason = "ResyncLBConfigFailed"
+	LBConfigDriftReason        = "LBConfigDrift"
 )
 
+// Options zero value fields use defaults
+type Options struct {
+	// TaskTimeout is the timeout of each loadbalancer task
+	TaskTimeout time.Duration
+	// MaxRetryDelay is the max backoff of failed task and the retry interval of parked service
+	MaxRetryDelay time.Duration
+	// ResyncInterval is how often configs of all services are re-applied, 0 means never
+	ResyncInterval time.Duration
+	// Workers is the count of goroutines processing tasks, tasks of different services are
+	// processed concurrently, tasks of the same service are processed in order one by one
+	Workers int
+	// DryRun means tasks are only planned, the plans are logged and sent as service events
+	DryRun bool
+}
+
 type LBControlManager struct {
-	clusterName string
-	recorder    record.EventRecorder
-	client      client.Client
-	driver      driver.Driver
-	taskCh      chan Task
-	stopCh      chan struct{}
-	nodes       map[string]string
-	lock        sync.Mutex
+	clusterName    string
+	recorder       record.EventRecorder
+	client         client.Client
+	drivers        *Drivers
+	queue          *taskQueue
+	taskTimeout    time.Duration
+	maxRetryDelay  time.Duration
+	resyncInterval time.Duration
+	dryRun         bool
+	ctx            context.Context
+	cancel         context.CancelFunc
+	stopCh         chan struct{}
+	nodes          map[string]nodeIPs
+	vips           *vipAllocator
+	lock           sync.Mutex
 }
 
-func New(cli client.Client, cache cache.Cache, config *rest.Config, clusterName string, lbDriver driver.Driver) (*LBControlManager, error) {
+func New(cli client.Client, cache cache.Cache, config *rest.Config, clusterName string, drivers *Drivers, opts Options) (*LBControlManager, error) {
 	ctrl := controller.New(ElbControllerName, cache, scheme.Scheme)
 	ctrl.Watch(&corev1.Endpoints{})
 	ctrl.Watch(&corev1.Service{})
 	ctrl.Watch(&corev1.Node{})
+	ctrl.Watch(&corev1.Secret{})
 
 	var options client.Options
 	options.Scheme = client.GetDefaultScheme()
@@ -65,39 +101,185 @@ func New(cli client.Client, cache cache.Cache, config *rest.Config, clusterName
 		return nil, err
 	}
 
+	svcs := &corev1.ServiceList{}
+	if err := cli.List(context.TODO(), &client.ListOptions{}, svcs); err != nil {
+		return nil, err
+	}
+	vips := newVIPAllocator(drivers.Resolve(""))
+	vips.Init(svcs.Items)
+
+	if opts.TaskTimeout <= 0 {
+		opts.TaskTimeout = DefaultTaskTimeout
+	}
+	if opts.MaxRetryDelay <= 0 {
+		opts.MaxRetryDelay = DefaultMaxRetryDelay
+	}
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
 	m := &LBControlManager{
-		clusterName: clusterName,
-		recorder:    r,
-		client:      cli,
-		driver:      lbDriver,
-		taskCh:      make(chan Task, taskBufferCount),
-		stopCh:      make(chan struct{}),
-		nodes:       nodes,
+		clusterName:    clusterName,
+		recorder:       r,
+		client:         cli,
+		drivers:        drivers,
+		queue:          newTaskQueue(),
+		taskTimeout:    opts.TaskTimeout,
+		maxRetryDelay:  opts.MaxRetryDelay,
+		resyncInterval: opts.ResyncInterval,
+		dryRun:         opts.DryRun,
+		ctx:            ctx,
+		cancel:         cancel,
+		stopCh:         make(chan struct{}),
+		nodes:          nodes,
+		vips:           vips,
 	}
 
 	go ctrl.Start(m.stopCh, m, predicate.NewIgnoreUnchangedUpdate())
-	go m.loop()
+	for i := 0; i < opts.Workers; i++ {
+		go m.loop(i)
+	}
+	if m.resyncInterval > 0 {
+		go m.resyncLoop()
+	}
 	return m, nil
 }
 
-func (m *LBControlManager) loop() {
+// Stop cancels the in-flight driver operations and stops the event watcher and task workers
+func (m *LBControlManager) Stop() {
+	m.cancel()
+	close(m.stopCh)
+	m.queue.ShutDown()
+}
+
+func (m *LBControlManager) loop(worker int) {
 	for {
-		t := <-m.taskCh
-		if isTaskFailureExceed(t) {
-			m.event(t)
-			continue
-		}
-		switch t.Type {
-		case CreateTask:
-			m.handleCreateTask(t)
-		case UpdateTask:
-			m.handleUpdateTask(t)
-		case DeleteTask:
-			m.handleDeleteTask(t)
-		default:
-			log.Warnf("[TaskLoop] unknown task type %s", t.Type)
+		t, ok := m.queue.Get()
+		if !ok {
+			log.Infof("[TaskLoop] worker %v stopped", worker)
+			return
 		}
+		m.handleTask(t)
+		m.queue.Done(t)
+	}
+}
+
+func (m *LBControlManager) handleTask(t Task) {
+	ctx, cancel := context.WithTimeout(m.ctx, m.taskTimeout)
+	defer cancel()
+
+	if err := m.loadLastApplied(ctx, &t); err != nil {
+		log.Warnf("[TaskLoop] task %s get last applied config failed %s", t.ToJson(), err.Error())
+		m.handleFailedTask(t, err, fmt.Sprintf("get last applied config failed %s", err.Error()))
+		return
+	}
+
+	lbDriver, err := m.drivers.Get(t.Driver)
+	var oldDriver driver.Driver
+	if err == nil {
+		oldDriver, err = m.drivers.Get(t.OldDriver)
+	}
+	if err != nil {
+		log.Warnf("[TaskLoop] drop task %s %s", t.ToJson(), err.Error())
+		t.ErrorMessage = err.Error()
+		m.event(t)
+		return
+	}
+
+	if m.dryRun {
+		m.handlePlanTask(ctx, oldDriver, lbDriver, t)
+		return
+	}
+
+	switch t.Type {
+	case CreateTask:
+		m.handleCreateTask(ctx, oldDriver, lbDriver, t)
+	case UpdateTask:
+		m.handleUpdateTask(ctx, oldDriver, lbDriver, t)
+	case DeleteTask:
+		m.handleDeleteTask(ctx, lbDriver, t)
+	case ResyncTask:
+		m.handleResyncTask(ctx, oldDriver, lbDriver, t)
+	default:
+		log.Warnf("[TaskLoop] unknown task type %s", t.Type)
+	}
+}
+
+// loadLastApplied makes the last applied config the old config of t, or the config
+// deleted by delete task, because configs generated from events may be different
+// from what is programmed, like after controller restarted or endpoints deleted
+func (m *LBControlManager) loadLastApplied(ctx context.Context, t *Task) error {
+	applied, err := getLastApplied(ctx, m.client, t.K8sService.Namespace, t.K8sService.Name)
+	if err != nil || applied == nil {
+		return err
+	}
+	if _, err := m.drivers.Get(applied.Driver); err != nil {
+		log.Warnf("[TaskLoop] ignore last applied config of service %s %s", t.Key(), err.Error())
+		return nil
+	}
+
+	if t.Type == DeleteTask {
+		t.NewConfig = applied.oldConfig(nil)
+		t.Driver = applied.Driver
+	} else {
+		t.OldConfig = applied.oldConfig(t.NewConfig)
 	}
+	t.OldDriver = applied.Driver
+	return nil
+}
+
+func (m *LBControlManager) handlePlanTask(ctx context.Context, oldDriver, lbDriver driver.Driver, t Task) {
+	var ops []driver.Operation
+	var err error
+	switch {
+	case t.Type == DeleteTask:
+		ops, err = lbDriver.Plan(ctx, t.NewConfig, nil)
+	case t.OldConfig == nil:
+		ops, err = lbDriver.Plan(ctx, nil, t.NewConfig)
+	case t.OldDriver != t.Driver:
+		ops, err = planMove(ctx, oldDriver, lbDriver, t)
+	default:
+		ops, err = lbDriver.Plan(ctx, t.OldConfig, t.NewConfig)
+	}
+	if err != nil {
+		log.Warnf("[TaskLoop] plan task %s failed %s", t.ToJson(), err.Error())
+		m.recorder.Event(t.K8sService, corev1.EventTypeWarning, PlanLBConfigFailedReason, fmt.Sprintf("plan %s loadbalance config failed %s", t.Type, err.Error()))
+		return
+	}
+
+	steps := make([]string, 0, len(ops))
+	for i, op := range ops {
+		log.Infof("[TaskLoop] dry-run %s task %s/%s step %v: %s", t.Type, t.K8sService.Namespace, t.K8sService.Name, i+1, op.String())
+		steps = append(steps, op.String())
+	}
+	if len(steps) == 0 {
+		steps = append(steps, "nothing to do")
+	}
+	m.recorder.Event(t.K8sService, corev1.EventTypeNormal, LBConfigPlannedReason, fmt.Sprintf("dry-run %s plan: %s", t.Type, strings.Join(steps, "; ")))
+}
+
+// planMove plans deleting old config from old driver then creating new config by new driver,
+// the driver name is added to the operation ids to tell them apart
+func planMove(ctx context.Context, oldDriver, lbDriver driver.Driver, t Task) ([]driver.Operation, error) {
+	deletes, err := oldDriver.Plan(ctx, t.OldConfig, nil)
+	if err != nil {
+		return nil, err
+	}
+	creates, err := lbDriver.Plan(ctx, nil, t.NewConfig)
+	if err != nil {
+		return nil, err
+	}
+	ops := make([]driver.Operation, 0, len(deletes)+len(creates))
+	for _, op := range deletes {
+		op.ID = t.OldDriver + ":" + op.ID
+		ops = append(ops, op)
+	}
+	for _, op := range creates {
+		op.ID = t.Driver + ":" + op.ID
+		ops = append(ops, op)
+	}
+	return ops, nil
 }
 
 func (m *LBControlManager) event(t Task) {
@@ -109,117 +291,217 @@ func (m *LBControlManager) event(t Task) {
 		reason = UpdateLBConfigFailedReason
 	case DeleteTask:
 		reason = DeleteLBConfigFailedReason
+	case ResyncTask:
+		reason = ResyncLBConfigFailedReason
 	}
 	m.recorder.Event(t.K8sService, corev1.EventTypeWarning, reason, t.ErrorMessage)
 }
 
-func isTaskFailureExceed(t Task) bool {
-	if t.Failures == maxTaskFailures {
-		log.Warnf("[TaskLoop] drop task %s due to exceed max task failures %v", t.ToJson(), maxTaskFailures)
-		return true
+// applyConfig applies new config of t on old config, nil old config means nothing is applied,
+// old config of another driver is deleted from it first, it may be deleted already when
+// the task is retried
+func applyConfig(ctx context.Context, oldDriver, lbDriver driver.Driver, t Task) error {
+	switch {
+	case t.OldConfig == nil:
+		return lbDriver.Create(ctx, *t.NewConfig)
+	case t.OldDriver != t.Driver:
+		if err := oldDriver.Delete(ctx, *t.OldConfig); err != nil && driver.ErrorTypeOf(err) != driver.ErrorNotFound {
+			return err
+		}
+		return lbDriver.Create(ctx, *t.NewConfig)
+	default:
+		return lbDriver.Update(ctx, *t.OldConfig, *t.NewConfig)
 	}
-	return false
 }
 
-func (m *LBControlManager) handleCreateTask(t Task) {
-	if err := m.driver.Create(*t.NewConfig); err != nil {
+func (m *LBControlManager) handleCreateTask(ctx context.Context, oldDriver, lbDriver driver.Driver, t Task) {
+	if err := applyConfig(ctx, oldDriver, lbDriver, t); err != nil {
 		log.Warnf("[TaskLoop] task %s failed %s", t.ToJson(), err.Error())
-		m.handleFailedTask(t, fmt.Sprintf("create loadbalance config failed %s", err.Error()))
+		m.handleFailedTask(t, err, fmt.Sprintf("create loadbalance config failed %s", err.Error()))
 		return
 	}
 	log.Debugf("[TaskLoop] task %s succeed", t.ToJson())
-	if err := addSvcFinalizerAndUpdateStatus(m.client, *t.NewConfig); err != nil {
-		log.Warnf("[TaskLoop] add service finalizer or update status failed %s", err.Error())
-		m.handleFailedTask(t, fmt.Sprintf("add service finalizer or update status failed %s", err.Error()))
+	m.driftCorrected(t)
+	if !m.updateServiceApplied(ctx, t) {
 		return
 	}
-	if err := addEpFinalizer(m.client, *t.NewConfig); err != nil {
+	if err := addEpFinalizer(ctx, m.client, *t.NewConfig); err != nil {
 		log.Warnf("[TaskLoop] add endpoints finalizer failed %s", err.Error())
-		m.handleFailedTask(t, fmt.Sprintf("add endpoints finalizer failed %s", err.Error()))
+		m.handleFailedTask(t, err, fmt.Sprintf("add endpoints finalizer failed %s", err.Error()))
 	}
 }
 
-func (m *LBControlManager) handleUpdateTask(t Task) {
-	if err := m.driver.Update(*t.OldConfig, *t.NewConfig); err != nil {
+func (m *LBControlManager) handleUpdateTask(ctx context.Context, oldDriver, lbDriver driver.Driver, t Task) {
+	if err := applyConfig(ctx, oldDriver, lbDriver, t); err != nil {
 		log.Warnf("[TaskLoop] task %s failed %s", t.ToJson(), err.Error())
-		m.handleFailedTask(t, fmt.Sprintf("update loadbalance config failed %s", err.Error()))
+		m.handleFailedTask(t, err, fmt.Sprintf("update loadbalance config failed %s", err.Error()))
 		return
 	}
 	log.Debugf("[TaskLoop] task %s succeed", t.ToJson())
-	if t.OldConfig.VIP == t.NewConfig.VIP {
+	m.driftCorrected(t)
+	m.updateServiceApplied(ctx, t)
+}
+
+// handleResyncTask re-creates the config which is idempotent to correct the changes made
+// on the device by others, the config different from last applied one is updated first
+// so that the objects which are removed from the config are deleted
+func (m *LBControlManager) handleResyncTask(ctx context.Context, oldDriver, lbDriver driver.Driver, t Task) {
+	if t.OldConfig != nil && encodeLastApplied(t.OldDriver, *t.OldConfig) != encodeLastApplied(t.Driver, *t.NewConfig) {
+		if err := applyConfig(ctx, oldDriver, lbDriver, t); err != nil {
+			log.Warnf("[TaskLoop] task %s failed %s", t.ToJson(), err.Error())
+			m.handleFailedTask(t, err, fmt.Sprintf("resync loadbalance config failed %s", err.Error()))
+			return
+		}
+	}
+	if err := lbDriver.Create(ctx, *t.NewConfig); err != nil {
+		log.Warnf("[TaskLoop] task %s failed %s", t.ToJson(), err.Error())
+		m.handleFailedTask(t, err, fmt.Sprintf("resync loadbalance config failed %s", err.Error()))
 		return
 	}
-	if err := addSvcFinalizerAndUpdateStatus(m.client, *t.NewConfig); err != nil {
-		log.Warnf("[TaskLoop] add service finalizer or update status failed %s", err.Error())
-		m.handleFailedTask(t, fmt.Sprintf("add service finalizer or update status failed %s", err.Error()))
+	log.Debugf("[TaskLoop] task %s succeed", t.ToJson())
+	m.driftCorrected(t)
+	m.updateServiceApplied(ctx, t)
+}
+
+// updateServiceApplied returns false if the service isn't updated, the task is retried
+func (m *LBControlManager) updateServiceApplied(ctx context.Context, t Task) bool {
+	if err := updateServiceApplied(ctx, m.client, t.Driver, *t.NewConfig); err != nil {
+		log.Warnf("[TaskLoop] update service finalizer, status or last applied config failed %s", err.Error())
+		m.handleFailedTask(t, err, fmt.Sprintf("update service finalizer, status or last applied config failed %s", err.Error()))
+		return false
 	}
+	return true
 }
 
-func (m *LBControlManager) handleDeleteTask(t Task) {
-	if err := m.driver.Delete(*t.NewConfig); err != nil {
-		log.Warnf("[TaskLoop] task %s failed %s", t.ToJson(), err.Error())
-		m.handleFailedTask(t, fmt.Sprintf("delete loadbalance config failed %s", err.Error()))
+// driftCorrected reports the drift found by resync is corrected by succeeded task t
+func (m *LBControlManager) driftCorrected(t Task) {
+	if t.Drift == "" {
 		return
 	}
+	driftCorrectedCount.Add(1)
+	log.Infof("[TaskLoop] service %s loadbalance config drift corrected: %s", t.Key(), t.Drift)
+	m.recorder.Event(t.K8sService, corev1.EventTypeWarning, LBConfigDriftReason, fmt.Sprintf("loadbalance config drift corrected: %s", t.Drift))
+}
+
+func (m *LBControlManager) handleDeleteTask(ctx context.Context, lbDriver driver.Driver, t Task) {
+	if err := lbDriver.Delete(ctx, *t.NewConfig); err != nil {
+		if driver.ErrorTypeOf(err) != driver.ErrorNotFound {
+			log.Warnf("[TaskLoop] task %s failed %s", t.ToJson(), err.Error())
+			m.handleFailedTask(t, err, fmt.Sprintf("delete loadbalance config failed %s", err.Error()))
+			return
+		}
+		log.Debugf("[TaskLoop] task %s config already deleted %s", t.ToJson(), err.Error())
+	}
 	log.Debugf("[TaskLoop] task %s succeed", t.ToJson())
-	if err := removeFinalizer(m.client, *t.NewConfig); err != nil {
+	if err := removeFinalizer(ctx, m.client, *t.NewConfig); err != nil {
 		log.Warnf("[TaskLoop] remove finalizer failed %s", err.Error())
-		m.handleFailedTask(t, fmt.Sprintf("remove finalizer failed %s", err.Error()))
+		m.handleFailedTask(t, err, fmt.Sprintf("remove finalizer failed %s", err.Error()))
 	}
 }
 
-func (m *LBControlManager) handleFailedTask(t Task, errMsg string) {
+// handleFailedTask decides the retry policy by driver error type: invalid config
+// never succeeds by retrying so the task is dropped until next event of the service,
+// others are retried with exponential backoff, the service is parked after
+// maxTaskFailures failures and retried every max retry delay, any new event of
+// a service waiting for retry makes it retried immediately
+func (m *LBControlManager) handleFailedTask(t Task, err error, errMsg string) {
+	if m.ctx.Err() != nil {
+		log.Warnf("[TaskLoop] drop task %s due to controller stopped", t.ToJson())
+		return
+	}
 	t.Failures += 1
 	t.ErrorMessage = errMsg
-	m.taskCh <- t
+
+	switch driver.ErrorTypeOf(err) {
+	case driver.ErrorInvalidConfig:
+		log.Warnf("[TaskLoop] drop task %s due to invalid config", t.ToJson())
+		m.event(t)
+	case driver.ErrorAuthFailure:
+		log.Errorf("[TaskLoop] park task %s due to loadbalancer auth failed, check driver user and password", t.ToJson())
+		m.recorder.Event(t.K8sService, corev1.EventTypeWarning, LBAuthFailedReason, t.ErrorMessage)
+		m.queue.AddAfter(t, m.maxRetryDelay)
+	default:
+		if t.Failures >= maxTaskFailures {
+			log.Warnf("[TaskLoop] park task %s due to exceed max task failures %v, retry after %s", t.ToJson(), maxTaskFailures, m.maxRetryDelay)
+			m.event(t)
+			m.queue.AddAfter(t, m.maxRetryDelay)
+			return
+		}
+		m.queue.AddAfter(t, retryDelay(t.Failures, m.maxRetryDelay))
+	}
 }
 
-func addSvcFinalizerAndUpdateStatus(cli client.Client, config driver.Config) error {
+// updateServiceApplied adds finalizer, records the applied config and sets vips as loadbalancer
+// ingress of the service, service isn't updated if nothing changes
+func updateServiceApplied(ctx context.Context, cli client.Client, driverName string, config driver.Config) error {
 	svc := &corev1.Service{}
-	if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, svc); err != nil {
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, svc); err != nil {
 		return err
 	}
 
-	helper.AddFinalizer(svc, ZcloudLBServiceFinalizer)
-	svc.Status.LoadBalancer = corev1.LoadBalancerStatus{
-		Ingress: []corev1.LoadBalancerIngress{
-			corev1.LoadBalancerIngress{
-				IP: config.VIP,
-			},
-		},
+	applied := encodeLastApplied(driverName, config)
+	if !helper.HasFinalizer(svc, ZcloudLBServiceFinalizer) || svc.Annotations[ZcloudLBLastAppliedAnnotationKey] != applied {
+		helper.AddFinalizer(svc, ZcloudLBServiceFinalizer)
+		if svc.Annotations == nil {
+			svc.Annotations = make(map[string]string)
+		}
+		svc.Annotations[ZcloudLBLastAppliedAnnotationKey] = applied
+		if err := cli.Update(ctx, svc); err != nil {
+			return err
+		}
 	}
-	if err := cli.Update(context.TODO(), svc); err != nil {
-		return err
+
+	ingress := []corev1.LoadBalancerIngress{}
+	for _, vip := range config.VIPs() {
+		ingress = append(ingress, corev1.LoadBalancerIngress{
+			IP: vip,
+		})
+	}
+	if reflect.DeepEqual(svc.Status.LoadBalancer.Ingress, ingress) {
+		return nil
+	}
+	svc.Status.LoadBalancer = corev1.LoadBalancerStatus{
+		Ingress: ingress,
 	}
-	return cli.Status().Update(context.TODO(), svc)
+	return cli.Status().Update(ctx, svc)
 }
 
-func addEpFinalizer(cli client.Client, config driver.Config) error {
+func addEpFinalizer(ctx context.Context, cli client.Client, config driver.Config) error {
 	ep := &corev1.Endpoints{}
-	if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, ep); err != nil {
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, ep); err != nil {
 		return err
 	}
 
 	helper.AddFinalizer(ep, ZcloudLBServiceFinalizer)
-	return cli.Update(context.TODO(), ep)
+	return cli.Update(ctx, ep)
 }
 
-func removeFinalizer(cli client.Client, config driver.Config) error {
+// removeFinalizer ignores the service or endpoints which are deleted already
+func removeFinalizer(ctx context.Context, cli client.Client, config driver.Config) error {
 	svc := &corev1.Service{}
-	if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, svc); err != nil {
-		return err
-	}
-	helper.RemoveFinalizer(svc, ZcloudLBServiceFinalizer)
-	if err := cli.Update(context.TODO(), svc); err != nil {
-		return err
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, svc); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return err
+		}
+	} else if helper.HasFinalizer(svc, ZcloudLBServiceFinalizer) {
+		helper.RemoveFinalizer(svc, ZcloudLBServiceFinalizer)
+		if err := cli.Update(ctx, svc); err != nil {
+			return err
+		}
 	}
 
 	ep := &corev1.Endpoints{}
-	if err := cli.Get(context.TODO(), types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, ep); err != nil {
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: config.K8sNamespace, Name: config.K8sService}, ep); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
 		return err
 	}
+	if !helper.HasFinalizer(ep, ZcloudLBServiceFinalizer) {
+		return nil
+	}
 	helper.RemoveFinalizer(ep, ZcloudLBServiceFinalizer)
-	return cli.Update(context.TODO(), ep)
+	return cli.Update(ctx, ep)
 }
 
 func (m *LBControlManager) OnCreate(e event.CreateEvent) (handler.Result, error) {
@@ -246,30 +528,41 @@ func (m *LBControlManager) onCreateEndpoints(ep *corev1.Endpoints) {
 		m.onDeleteService(svc)
 		return
 	}
+	if !m.isServiceValid(svc) || !m.assignVIP(svc) {
+		return
+	}
+	secrets, ok := m.getServiceTLSSecrets(svc)
+	if !ok {
+		return
+	}
 	log.Debugf("[Event] service %s created", genObjNamespacedName(svc.Namespace, svc.Name))
-	config := genLBConfig(svc, ep, m.clusterName, m.nodes)
-	m.taskCh <- NewTask(CreateTask, nil, &config, svc)
+	config := genLBConfig(svc, ep, m.clusterName, m.nodes, secrets)
+	m.queue.Add(NewTask(CreateTask, m.getDriverName(svc), nil, &config, svc))
 }
 
 func (m *LBControlManager) onCreateNode(n *corev1.Node) {
 	log.Debugf("[Event] node %s created", n.Name)
 	m.lock.Lock()
 	defer m.lock.Unlock()
-	m.nodes[n.Name] = n.Status.Addresses[0].Address
+	m.nodes[n.Name] = getNodeIPs(n)
 }
 
 func (m *LBControlManager) onDeleteService(s *corev1.Service) {
 	if !isServiceNeedHandle(s) {
 		return
 	}
+	// endpoints may be deleted already, the backends to delete are in the last applied config
 	ep := &corev1.Endpoints{}
-	if err := m.client.Get(context.TODO(), types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, ep); err != nil {
+	if err := m.client.Get(context.TODO(), types.NamespacedName{Namespace: s.Namespace, Name: s.Name}, ep); err != nil && !apierrors.IsNotFound(err) {
 		log.Warnf("[Event] get service %s endpoints failed %s", genObjNamespacedName(s.Namespace, s.Name), err.Error())
 		return
 	}
 	log.Debugf("[Event] service %s deleted", genObjNamespacedName(s.Namespace, s.Name))
-	config := genLBConfig(s, ep, m.clusterName, m.nodes)
-	m.taskCh <- NewTask(DeleteTask, nil, &config, s)
+	m.vips.Release(s)
+	// certificate isn't needed by delete, so the secret may be deleted already
+	secrets, _ := getTLSSecrets(context.TODO(), m.client, s)
+	config := genLBConfig(s, ep, m.clusterName, m.nodes, secrets)
+	m.queue.Add(NewTask(DeleteTask, m.getDriverName(s), nil, &config, s))
 }
 
 func (m *LBControlManager) OnUpdate(e event.UpdateEvent) (handler.Result, error) {
@@ -280,12 +573,16 @@ func (m *LBControlManager) OnUpdate(e event.UpdateEvent) (handler.Result, error)
 	case *corev1.Endpoints:
 		old := e.ObjectOld.(*corev1.Endpoints)
 		m.onUpdateEndpoints(old, new)
+	case *corev1.Secret:
+		old := e.ObjectOld.(*corev1.Secret)
+		m.onUpdateSecret(old, new)
 	}
 	return handler.Result{}, nil
 }
 
 func (m *LBControlManager) onUpdateService(old, new *corev1.Service) {
 	if !isServiceNeedHandle(new) {
+		m.vips.Release(new)
 		return
 	}
 
@@ -294,7 +591,10 @@ func (m *LBControlManager) onUpdateService(old, new *corev1.Service) {
 		m.onDeleteService(new)
 		return
 	}
-	if reflect.DeepEqual(old.ObjectMeta.Annotations, new.ObjectMeta.Annotations) && reflect.DeepEqual(old.Spec, new.Spec) {
+	if !isAnnotationsChanged(old, new) && reflect.DeepEqual(old.Spec, new.Spec) {
+		return
+	}
+	if !m.isServiceValid(new) || !m.assignVIP(new) {
 		return
 	}
 
@@ -305,9 +605,21 @@ func (m *LBControlManager) onUpdateService(old, new *corev1.Service) {
 		return
 	}
 
-	oldConfig := genLBConfig(old, ep, m.clusterName, m.nodes)
-	newConfig := genLBConfig(new, ep, m.clusterName, m.nodes)
-	m.taskCh <- NewTask(UpdateTask, &oldConfig, &newConfig, new)
+	secrets, ok := m.getServiceTLSSecrets(new)
+	if !ok {
+		return
+	}
+	oldSecrets, _ := getTLSSecrets(context.TODO(), m.client, old)
+	oldConfig := genLBConfig(old, ep, m.clusterName, m.nodes, oldSecrets)
+	newConfig := genLBConfig(new, ep, m.clusterName, m.nodes, secrets)
+	t := NewTask(UpdateTask, m.getDriverName(new), &oldConfig, &newConfig, new)
+	// old config was never applied if old driver doesn't exist, so the service is created
+	if _, err := m.drivers.Get(getLBDriverName(old)); err == nil {
+		t.OldDriver = m.getDriverName(old)
+	} else {
+		t.OldConfig = nil
+	}
+	m.queue.Add(t)
 }
 
 func (m *LBControlManager) onUpdateEndpoints(old, new *corev1.Endpoints) {
@@ -321,18 +633,110 @@ func (m *LBControlManager) onUpdateEndpoints(old, new *corev1.Endpoints) {
 		return
 	}
 
-	if !isServiceNeedHandle(svc) {
+	if !isServiceNeedHandle(svc) || !m.isServiceValid(svc) || !m.assignVIP(svc) {
 		return
 	}
 
+	secrets, ok := m.getServiceTLSSecrets(svc)
+	if !ok {
+		return
+	}
 	log.Debugf("[Event] endpoints %s updated", genObjNamespacedName(new.Namespace, new.Name))
-	oldConfig := genLBConfig(svc, old, m.clusterName, m.nodes)
-	newConfig := genLBConfig(svc, new, m.clusterName, m.nodes)
-	m.taskCh <- NewTask(UpdateTask, &oldConfig, &newConfig, svc)
+	oldConfig := genLBConfig(svc, old, m.clusterName, m.nodes, secrets)
+	newConfig := genLBConfig(svc, new, m.clusterName, m.nodes, secrets)
+	m.queue.Add(NewTask(UpdateTask, m.getDriverName(svc), &oldConfig, &newConfig, svc))
+}
+
+// onUpdateSecret rotates the certificate of services which refer to the tls secret
+func (m *LBControlManager) onUpdateSecret(old, new *corev1.Secret) {
+	if new.Type != corev1.SecretTypeTLS || reflect.DeepEqual(old.Data, new.Data) {
+		return
+	}
+
+	svcs := &corev1.ServiceList{}
+	if err := m.client.List(context.TODO(), &client.ListOptions{Namespace: new.Namespace}, svcs); err != nil {
+		log.Warnf("[Event] list secret %s services failed %s", genObjNamespacedName(new.Namespace, new.Name), err.Error())
+		return
+	}
+
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		if !isServiceNeedHandle(svc) || svc.DeletionTimestamp != nil || !isServiceReferSecret(svc, new.Name) || !m.isServiceValid(svc) {
+			continue
+		}
+		ep := &corev1.Endpoints{}
+		if err := m.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, ep); err != nil {
+			log.Warnf("[Event] get service %s endpoints failed %s", genObjNamespacedName(svc.Namespace, svc.Name), err.Error())
+			continue
+		}
+		secrets, ok := m.getServiceTLSSecrets(svc)
+		if !ok {
+			continue
+		}
+		oldSecrets := make(map[string]*corev1.Secret)
+		for name, secret := range secrets {
+			oldSecrets[name] = secret
+		}
+		oldSecrets[old.Name] = old
+		secrets[new.Name] = new
+
+		log.Debugf("[Event] secret %s updated, rotate service %s certificate", genObjNamespacedName(new.Namespace, new.Name), genObjNamespacedName(svc.Namespace, svc.Name))
+		oldConfig := genLBConfig(svc, ep, m.clusterName, m.nodes, oldSecrets)
+		newConfig := genLBConfig(svc, ep, m.clusterName, m.nodes, secrets)
+		m.queue.Add(NewTask(UpdateTask, m.getDriverName(svc), &oldConfig, &newConfig, svc))
+	}
+}
+
+func (m *LBControlManager) getServiceTLSSecrets(svc *corev1.Service) (map[string]*corev1.Secret, bool) {
+	secrets, err := getTLSSecrets(context.TODO(), m.client, svc)
+	if err != nil {
+		log.Warnf("[Event] service %s %s", genObjNamespacedName(svc.Namespace, svc.Name), err.Error())
+		m.recorder.Event(svc, corev1.EventTypeWarning, GetTLSSecretFailedReason, err.Error())
+		return nil, false
+	}
+	return secrets, true
+}
+
+// getDriverName returns the driver instance name of svc, it's resolved so that
+// moving between empty and explicit default driver isn't a move
+func (m *LBControlManager) getDriverName(svc *corev1.Service) string {
+	return m.drivers.Resolve(getLBDriverName(svc))
+}
+
+func (m *LBControlManager) validateService(svc *corev1.Service) error {
+	lbDriver, err := m.drivers.Get(getLBDriverName(svc))
+	if err != nil {
+		return err
+	}
+	if err := validateService(svc, lbDriver.Capabilities()); err != nil {
+		return err
+	}
+	return validateDrainSeconds(svc, m.taskTimeout)
+}
+
+func (m *LBControlManager) isServiceValid(svc *corev1.Service) bool {
+	if err := m.validateService(svc); err != nil {
+		log.Warnf("[Event] service %s is invalid %s", genObjNamespacedName(svc.Namespace, svc.Name), err.Error())
+		m.recorder.Event(svc, corev1.EventTypeWarning, InvalidLBConfigReason, err.Error())
+		return false
+	}
+	return true
+}
+
+// assignVIP rejects the service whose vip ports conflict with other services
+func (m *LBControlManager) assignVIP(svc *corev1.Service) bool {
+	if err := m.vips.Assign(svc); err != nil {
+		log.Warnf("[Event] service %s vip conflicts %s", genObjNamespacedName(svc.Namespace, svc.Name), err.Error())
+		m.recorder.Event(svc, corev1.EventTypeWarning, VIPConflictReason, err.Error())
+		return false
+	}
+	return true
 }
 
 func (m *LBControlManager) OnDelete(e event.DeleteEvent) (handler.Result, error) {
 	switch obj := e.Object.(type) {
+	case *corev1.Service:
+		m.vips.Release(obj)
 	case *corev1.Node:
 		m.onDeleteNode(obj)
 	}
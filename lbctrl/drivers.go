@@ -0,0 +1,66 @@
+package lbctrl
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/zdnscloud/elb-controller/driver"
+
+	"github.com/zdnscloud/cement/log"
+)
+
+const DefaultDriverName = "default"
+
+// Drivers are named driver instances, a service selects one by annotation
+// lb.zcloud.cn/driver, service without the annotation uses the default one
+type Drivers struct {
+	drivers     map[string]driver.Driver
+	defaultName string
+}
+
+func NewDrivers(defaultName string, drivers map[string]driver.Driver) (*Drivers, error) {
+	if _, ok := drivers[defaultName]; !ok {
+		return nil, fmt.Errorf("default driver %s doesn't exist", defaultName)
+	}
+	return &Drivers{
+		drivers:     drivers,
+		defaultName: defaultName,
+	}, nil
+}
+
+// Resolve returns the driver name used by empty name
+func (d *Drivers) Resolve(name string) string {
+	if name == "" {
+		return d.defaultName
+	}
+	return name
+}
+
+func (d *Drivers) Get(name string) (driver.Driver, error) {
+	lbDriver, ok := d.drivers[d.Resolve(name)]
+	if !ok {
+		return nil, fmt.Errorf("driver %s doesn't exist, drivers are %v", name, d.Names())
+	}
+	return lbDriver, nil
+}
+
+func (d *Drivers) Names() []string {
+	names := make([]string, 0, len(d.drivers))
+	for name := range d.drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Close closes the drivers which hold resources, like plugin processes
+func (d *Drivers) Close() {
+	for _, name := range d.Names() {
+		if closer, ok := d.drivers[name].(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				log.Warnf("close driver %s failed %s", name, err.Error())
+			}
+		}
+	}
+}
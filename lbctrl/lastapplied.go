@@ -0,0 +1,120 @@
+package lbctrl
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+
+	"github.com/zdnscloud/elb-controller/driver"
+
+	"github.com/zdnscloud/cement/log"
+	"github.com/zdnscloud/gok8s/client"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// lastApplied is the value of annotation lb.zcloud.cn/last-applied, it's the config
+// programmed by the last succeeded task, tls certificates and keys aren't stored,
+// TLSDigests keyed by protocol/port are used to tell whether they are changed
+type lastApplied struct {
+	Driver     string            `json:"driver"`
+	Config     driver.Config     `json:"config"`
+	TLSDigests map[string]string `json:"tlsDigests,omitempty"`
+}
+
+func encodeLastApplied(driverName string, c driver.Config) string {
+	applied := lastApplied{
+		Driver:     driverName,
+		TLSDigests: make(map[string]string),
+	}
+	services := make([]driver.Service, 0, len(c.Services))
+	for _, s := range c.Services {
+		if s.TLS != nil {
+			applied.TLSDigests[genServicePortKey(s)] = genTLSDigest(s.TLS)
+			s.TLS = &driver.TLS{SecretName: s.TLS.SecretName}
+		}
+		services = append(services, s)
+	}
+	c.Services = services
+	applied.Config = c
+	b, _ := json.Marshal(applied)
+	return string(b)
+}
+
+// decodeLastApplied returns nil if svc has no last applied config
+func decodeLastApplied(svc *corev1.Service) (*lastApplied, error) {
+	v, ok := svc.Annotations[ZcloudLBLastAppliedAnnotationKey]
+	if !ok {
+		return nil, nil
+	}
+	var applied lastApplied
+	if err := json.Unmarshal([]byte(v), &applied); err != nil {
+		return nil, err
+	}
+	return &applied, nil
+}
+
+// oldConfig returns the applied config, certificates and keys which aren't changed
+// are copied from new, so that the driver doesn't take them as changed
+func (a *lastApplied) oldConfig(new *driver.Config) *driver.Config {
+	c := a.Config
+	if new == nil {
+		return &c
+	}
+	tlss := make(map[string]*driver.TLS)
+	for _, s := range new.Services {
+		if s.TLS != nil {
+			tlss[genServicePortKey(s)] = s.TLS
+		}
+	}
+	services := make([]driver.Service, 0, len(c.Services))
+	for _, s := range c.Services {
+		key := genServicePortKey(s)
+		if tls, ok := tlss[key]; ok && s.TLS != nil && a.TLSDigests[key] == genTLSDigest(tls) {
+			s.TLS = tls
+		}
+		services = append(services, s)
+	}
+	c.Services = services
+	return &c
+}
+
+func genTLSDigest(tls *driver.TLS) string {
+	sum := sha256.Sum256([]byte(tls.Certificate + tls.Key))
+	return hex.EncodeToString(sum[:])
+}
+
+func getLastApplied(ctx context.Context, cli client.Client, namespace, name string) (*lastApplied, error) {
+	svc := &corev1.Service{}
+	if err := cli.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, svc); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	// invalid annotation is ignored, it's overwritten after the task succeeds
+	applied, err := decodeLastApplied(svc)
+	if err != nil {
+		log.Warnf("[TaskLoop] service %s annotation %s is invalid %s", genObjNamespacedName(namespace, name), ZcloudLBLastAppliedAnnotationKey, err.Error())
+		return nil, nil
+	}
+	return applied, nil
+}
+
+// isAnnotationsChanged ignores the last applied annotation which is updated by controller
+func isAnnotationsChanged(old, new *corev1.Service) bool {
+	return !reflect.DeepEqual(withoutLastApplied(old.Annotations), withoutLastApplied(new.Annotations))
+}
+
+func withoutLastApplied(annotations map[string]string) map[string]string {
+	result := make(map[string]string)
+	for k, v := range annotations {
+		if k != ZcloudLBLastAppliedAnnotationKey {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,13 @@
+package lbctrl
+
+import (
+	"expvar"
+)
+
+// metrics are published by expvar, they are served on /debug/vars of the http default mux
+var (
+	resyncCount          = expvar.NewInt("elbc_resync_total")
+	inventoryFailedCount = expvar.NewInt("elbc_inventory_failures_total")
+	driftDetectedCount   = expvar.NewInt("elbc_drift_detected_total")
+	driftCorrectedCount  = expvar.NewInt("elbc_drift_corrected_total")
+)
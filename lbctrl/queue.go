@@ -0,0 +1,153 @@
+package lbctrl
+
+import (
+	"sync"
+	"time"
+)
+
+// taskQueue holds at most one pending task for each service, a task added while
+// the service has a pending one is merged into it, so a burst of events of a
+// service is applied as a single task; a service is never processed concurrently,
+// task added while its service is processing is queued after Done
+type taskQueue struct {
+	lock       sync.Mutex
+	cond       *sync.Cond
+	keys       []string
+	pending    map[string]Task
+	processing map[string]bool
+	waiting    map[string]*waitingTask
+	stopped    bool
+}
+
+// waitingTask is a failed task waiting for retry
+type waitingTask struct {
+	task  Task
+	timer *time.Timer
+}
+
+func newTaskQueue() *taskQueue {
+	q := &taskQueue{
+		pending:    make(map[string]Task),
+		processing: make(map[string]bool),
+		waiting:    make(map[string]*waitingTask),
+	}
+	q.cond = sync.NewCond(&q.lock)
+	return q
+}
+
+// Add queues task of new event, the failed task of the service waiting for retry
+// is merged and retried now
+func (q *taskQueue) Add(t Task) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	key := t.Key()
+	if w, ok := q.waiting[key]; ok {
+		w.timer.Stop()
+		delete(q.waiting, key)
+		t = mergeTask(w.task, t)
+	}
+	q.add(t)
+}
+
+// AddAfter queues failed task after delay without blocking, newer pending task of
+// the service is merged into it
+func (q *taskQueue) AddAfter(t Task, delay time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.stopped {
+		return
+	}
+	key := t.Key()
+	if _, ok := q.pending[key]; ok || delay <= 0 {
+		// newer task added during processing is retried with it now
+		q.retry(t)
+		return
+	}
+	if w, ok := q.waiting[key]; ok {
+		w.timer.Stop()
+	}
+	w := &waitingTask{task: t}
+	w.timer = time.AfterFunc(delay, func() {
+		q.lock.Lock()
+		defer q.lock.Unlock()
+		if q.waiting[key] != w {
+			return
+		}
+		delete(q.waiting, key)
+		q.retry(w.task)
+	})
+	q.waiting[key] = w
+}
+
+func (q *taskQueue) retry(t Task) {
+	key := t.Key()
+	if pending, ok := q.pending[key]; ok {
+		// key of the pending task is queued already or will be queued by Done
+		q.pending[key] = mergeTask(t, pending)
+		return
+	}
+	q.add(t)
+}
+
+func (q *taskQueue) add(t Task) {
+	if q.stopped {
+		return
+	}
+	key := t.Key()
+	if pending, ok := q.pending[key]; ok {
+		q.pending[key] = mergeTask(pending, t)
+		return
+	}
+	q.pending[key] = t
+	if !q.processing[key] {
+		q.keys = append(q.keys, key)
+		q.cond.Signal()
+	}
+}
+
+// Get blocks until a task is ready, it returns false after the queue is shut down
+func (q *taskQueue) Get() (Task, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.keys) == 0 && !q.stopped {
+		q.cond.Wait()
+	}
+	if q.stopped {
+		return Task{}, false
+	}
+	key := q.keys[0]
+	q.keys = q.keys[1:]
+	t := q.pending[key]
+	delete(q.pending, key)
+	q.processing[key] = true
+	return t, true
+}
+
+// Done marks the service of t processed, task added during processing becomes ready
+func (q *taskQueue) Done(t Task) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	key := t.Key()
+	delete(q.processing, key)
+	if _, ok := q.pending[key]; ok && !q.stopped {
+		q.keys = append(q.keys, key)
+		q.cond.Signal()
+	}
+}
+
+func (q *taskQueue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.pending) + len(q.waiting)
+}
+
+func (q *taskQueue) ShutDown() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.stopped = true
+	for _, w := range q.waiting {
+		w.timer.Stop()
+	}
+	q.waiting = make(map[string]*waitingTask)
+	q.cond.Broadcast()
+}
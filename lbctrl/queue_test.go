@@ -0,0 +1,142 @@
+package lbctrl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zdnscloud/elb-controller/driver"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestTask(t TaskType, name, vip string, old *driver.Config) Task {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+	config := &driver.Config{K8sNamespace: "default", K8sService: name, VIP: vip}
+	return NewTask(t, DefaultDriverName, old, config, svc)
+}
+
+// getTask gets a ready task without blocking
+func getTask(t *testing.T, q *taskQueue) Task {
+	q.lock.Lock()
+	ready := len(q.keys)
+	q.lock.Unlock()
+	if ready == 0 {
+		t.Fatal("no task is ready")
+	}
+	task, ok := q.Get()
+	if !ok || task.K8sService == nil {
+		t.Fatalf("got zero task from queue")
+	}
+	return task
+}
+
+func readyKeys(q *taskQueue) int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.keys)
+}
+
+func TestQueueMerge(t *testing.T) {
+	q := newTaskQueue()
+	q.Add(newTestTask(CreateTask, "web", "192.0.2.1", nil))
+	q.Add(newTestTask(UpdateTask, "web", "192.0.2.2", &driver.Config{VIP: "192.0.2.1"}))
+	q.Add(newTestTask(CreateTask, "db", "192.0.2.3", nil))
+
+	if q.Len() != 2 || readyKeys(q) != 2 {
+		t.Fatalf("tasks of the same service should be merged, len %v ready %v", q.Len(), readyKeys(q))
+	}
+	task := getTask(t, q)
+	if task.K8sService.Name != "web" || task.Type != CreateTask || task.NewConfig.VIP != "192.0.2.2" || task.OldConfig != nil {
+		t.Fatalf("create and update should be merged into create of the latest config but got %s", task.ToJson())
+	}
+
+	// task added during processing waits for Done
+	q.Add(newTestTask(UpdateTask, "web", "192.0.2.4", &driver.Config{VIP: "192.0.2.2"}))
+	if task := getTask(t, q); task.K8sService.Name != "db" {
+		t.Fatalf("processing service shouldn't be ready but got %s", task.Key())
+	}
+	if readyKeys(q) != 0 {
+		t.Fatal("processing service shouldn't be ready before done")
+	}
+	q.Done(task)
+	if task := getTask(t, q); task.NewConfig.VIP != "192.0.2.4" {
+		t.Fatalf("task added during processing should be ready after done but got %s", task.ToJson())
+	}
+}
+
+func TestQueueRetryWhilePending(t *testing.T) {
+	q := newTaskQueue()
+	q.Add(newTestTask(UpdateTask, "web", "192.0.2.2", &driver.Config{VIP: "192.0.2.1"}))
+	failed := getTask(t, q)
+	q.Add(newTestTask(UpdateTask, "web", "192.0.2.3", &driver.Config{VIP: "192.0.2.2"}))
+	q.AddAfter(failed, time.Hour)
+	q.Done(failed)
+
+	if q.Len() != 1 || readyKeys(q) != 1 {
+		t.Fatalf("failed task should be merged with the pending one, len %v ready %v", q.Len(), readyKeys(q))
+	}
+	task := getTask(t, q)
+	if task.OldConfig.VIP != "192.0.2.1" || task.NewConfig.VIP != "192.0.2.3" {
+		t.Fatalf("merged task should start from the failed one but got %s", task.ToJson())
+	}
+	q.Done(task)
+
+	// retry timer fires while a newer task is pending
+	q.Add(newTestTask(UpdateTask, "web", "192.0.2.4", &driver.Config{VIP: "192.0.2.3"}))
+	q.lock.Lock()
+	q.retry(failed)
+	q.lock.Unlock()
+	if readyKeys(q) != 1 {
+		t.Fatalf("service should be ready once but got %v", readyKeys(q))
+	}
+	task = getTask(t, q)
+	if task.OldConfig.VIP != "192.0.2.1" || task.NewConfig.VIP != "192.0.2.4" {
+		t.Fatalf("merged task should start from the failed one but got %s", task.ToJson())
+	}
+	q.Done(task)
+	if readyKeys(q) != 0 || q.Len() != 0 {
+		t.Fatalf("queue should be empty, len %v ready %v", q.Len(), readyKeys(q))
+	}
+}
+
+func TestQueuePark(t *testing.T) {
+	q := newTaskQueue()
+	q.Add(newTestTask(CreateTask, "web", "192.0.2.1", nil))
+	failed := getTask(t, q)
+	q.AddAfter(failed, 20*time.Millisecond)
+	q.Done(failed)
+
+	if q.Len() != 1 || readyKeys(q) != 0 {
+		t.Fatalf("parked task shouldn't be ready, len %v ready %v", q.Len(), readyKeys(q))
+	}
+	time.Sleep(100 * time.Millisecond)
+	task := getTask(t, q)
+	if task.ID != failed.ID {
+		t.Fatalf("parked task should be retried but got %s", task.ToJson())
+	}
+}
+
+func TestQueueUnpark(t *testing.T) {
+	q := newTaskQueue()
+	q.Add(newTestTask(CreateTask, "web", "192.0.2.1", nil))
+	failed := getTask(t, q)
+	failed.Failures = maxTaskFailures
+	q.AddAfter(failed, time.Hour)
+	q.Done(failed)
+
+	q.Add(newTestTask(UpdateTask, "web", "192.0.2.2", &driver.Config{VIP: "192.0.2.1"}))
+	if q.Len() != 1 || readyKeys(q) != 1 {
+		t.Fatalf("new event should retry parked task now, len %v ready %v", q.Len(), readyKeys(q))
+	}
+	task := getTask(t, q)
+	if task.Type != CreateTask || task.NewConfig.VIP != "192.0.2.2" {
+		t.Fatalf("parked create should be merged with new event but got %s", task.ToJson())
+	}
+	q.Done(task)
+
+	q.ShutDown()
+	if _, ok := q.Get(); ok {
+		t.Fatal("get should return false after shut down")
+	}
+}
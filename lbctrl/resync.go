@@ -0,0 +1,173 @@
+package lbctrl
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/zdnscloud/elb-controller/driver"
+
+	"github.com/zdnscloud/cement/log"
+	"github.com/zdnscloud/gok8s/client"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const DefaultResyncInterval = 30 * time.Minute
+
+func (m *LBControlManager) resyncLoop() {
+	ticker := time.NewTicker(m.resyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.resync()
+		}
+	}
+}
+
+// resync re-applies the desired config of every managed service, the device may be
+// changed by others, so the desired config is compared with the inventory of the
+// driver and the difference is reported as drift once it's corrected
+func (m *LBControlManager) resync() {
+	ctx, cancel := context.WithTimeout(m.ctx, m.taskTimeout)
+	defer cancel()
+
+	svcs := &corev1.ServiceList{}
+	if err := m.client.List(ctx, &client.ListOptions{}, svcs); err != nil {
+		log.Warnf("[Resync] list services failed %s", err.Error())
+		return
+	}
+	resyncCount.Add(1)
+	observed := m.inventory(ctx)
+
+	count := 0
+	for i := range svcs.Items {
+		svc := &svcs.Items[i]
+		if !isServiceNeedHandle(svc) || svc.DeletionTimestamp != nil {
+			continue
+		}
+		config, ok := m.genResyncConfig(ctx, svc)
+		if !ok {
+			continue
+		}
+
+		t := NewTask(ResyncTask, m.getDriverName(svc), nil, &config, svc)
+		if configs, ok := observed[t.Driver]; ok {
+			t.Drift = diffConfig(config, configs[t.Key()])
+		}
+		if t.Drift != "" {
+			driftDetectedCount.Add(1)
+			log.Warnf("[Resync] service %s loadbalance config drifts: %s", t.Key(), t.Drift)
+		}
+		if m.dryRun {
+			continue
+		}
+		m.queue.Add(t)
+		count += 1
+	}
+	log.Debugf("[Resync] %v services are resynced", count)
+}
+
+// genResyncConfig returns false if the service is skipped, the reason is reported by its events already
+func (m *LBControlManager) genResyncConfig(ctx context.Context, svc *corev1.Service) (driver.Config, bool) {
+	if err := m.validateService(svc); err != nil {
+		return driver.Config{}, false
+	}
+	if err := m.vips.Assign(svc); err != nil {
+		return driver.Config{}, false
+	}
+	ep := &corev1.Endpoints{}
+	if err := m.client.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, ep); err != nil {
+		log.Warnf("[Resync] get service %s endpoints failed %s", genObjNamespacedName(svc.Namespace, svc.Name), err.Error())
+		return driver.Config{}, false
+	}
+	secrets, err := getTLSSecrets(ctx, m.client, svc)
+	if err != nil {
+		return driver.Config{}, false
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return genLBConfig(svc, ep, m.clusterName, m.nodes, secrets), true
+}
+
+// inventory returns the observed configs keyed by driver name and service namespace/name,
+// driver whose inventory failed is absent, so drift of its services isn't detected
+func (m *LBControlManager) inventory(ctx context.Context) map[string]map[string]*driver.Config {
+	result := make(map[string]map[string]*driver.Config)
+	for _, name := range m.drivers.Names() {
+		lbDriver, _ := m.drivers.Get(name)
+		configs, err := lbDriver.Inventory(ctx, m.clusterName)
+		if err != nil {
+			inventoryFailedCount.Add(1)
+			log.Warnf("[Resync] driver %s inventory failed %s", name, err.Error())
+			continue
+		}
+		observed := make(map[string]*driver.Config)
+		for i := range configs {
+			observed[genObjNamespacedName(configs[i].K8sNamespace, configs[i].K8sService)] = &configs[i]
+		}
+		result[name] = observed
+	}
+	return result
+}
+
+// diffConfig compares the fields every driver observes, it returns empty string if no difference
+func diffConfig(desired driver.Config, observed *driver.Config) string {
+	if observed == nil {
+		return "config isn't found on loadbalancer"
+	}
+	diffs := []string{}
+	if desired.VIP != observed.VIP || desired.VIPv6 != observed.VIPv6 {
+		diffs = append(diffs, fmt.Sprintf("vip %v is changed to %v", observed.VIPs(), desired.VIPs()))
+	}
+	if desired.Method != observed.Method {
+		diffs = append(diffs, fmt.Sprintf("method %s is changed to %s", observed.Method, desired.Method))
+	}
+
+	services := make(map[string]driver.Service)
+	for _, s := range observed.Services {
+		services[genServicePortKey(s)] = s
+	}
+	for _, s := range desired.Services {
+		key := genServicePortKey(s)
+		o, ok := services[key]
+		if !ok {
+			diffs = append(diffs, fmt.Sprintf("port %s is missing", key))
+			continue
+		}
+		delete(services, key)
+		if s.BackendPort != o.BackendPort {
+			diffs = append(diffs, fmt.Sprintf("port %s backend port %v is changed to %v", key, o.BackendPort, s.BackendPort))
+		}
+		if !isHostsEqual(s.BackendHosts, o.BackendHosts) || !isHostsEqual(s.BackendHostsV6, o.BackendHostsV6) {
+			diffs = append(diffs, fmt.Sprintf("port %s backends are changed", key))
+		}
+	}
+	for key := range services {
+		diffs = append(diffs, fmt.Sprintf("port %s is unexpected", key))
+	}
+	sort.Strings(diffs)
+	return strings.Join(diffs, ", ")
+}
+
+func genServicePortKey(s driver.Service) string {
+	return fmt.Sprintf("%s/%v", s.Protocol, s.Port)
+}
+
+func isHostsEqual(a, b []string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
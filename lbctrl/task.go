@@ -15,30 +15,86 @@ const (
 	CreateTask TaskType = "create"
 	UpdateTask TaskType = "update"
 	DeleteTask TaskType = "delete"
+	// ResyncTask re-applies the config by driver Create which is idempotent, k8s objects aren't changed
+	ResyncTask TaskType = "resync"
 )
 
+// Task Driver is the driver instance of NewConfig, OldDriver is the one of OldConfig,
+// they are different when the service moves to another driver
 type Task struct {
-	ID           string          `json:"id"`
-	Type         TaskType        `json:"type"`
-	OldConfig    *driver.Config  `json:"oldConfig,omitempty"`
-	NewConfig    *driver.Config  `json:"newConfig"`
-	Failures     int             `json:"failures"`
+	ID        string         `json:"id"`
+	Type      TaskType       `json:"type"`
+	Driver    string         `json:"driver"`
+	OldDriver string         `json:"oldDriver,omitempty"`
+	OldConfig *driver.Config `json:"oldConfig,omitempty"`
+	NewConfig *driver.Config `json:"newConfig"`
+	Failures  int            `json:"failures"`
+	// Drift is the difference between desired and observed config found by resync
+	Drift        string          `json:"drift,omitempty"`
 	K8sService   *corev1.Service `json:"-"`
 	ErrorMessage string          `json:"-"`
 }
 
-func NewTask(t TaskType, old, new *driver.Config, svc *corev1.Service) Task {
+func NewTask(t TaskType, driverName string, old, new *driver.Config, svc *corev1.Service) Task {
 	id, _ := uuid.Gen()
 	return Task{
 		ID:         id,
 		Type:       t,
+		Driver:     driverName,
+		OldDriver:  driverName,
 		OldConfig:  old,
 		NewConfig:  new,
 		K8sService: svc,
 	}
 }
 
+// ToJson is used for logging, so private keys in configs are redacted
 func (t Task) ToJson() string {
+	if t.OldConfig != nil {
+		c := t.OldConfig.Redact()
+		t.OldConfig = &c
+	}
+	if t.NewConfig != nil {
+		c := t.NewConfig.Redact()
+		t.NewConfig = &c
+	}
 	b, _ := json.Marshal(&t)
 	return string(b)
 }
+
+func (t Task) Key() string {
+	return genObjNamespacedName(t.K8sService.Namespace, t.K8sService.Name)
+}
+
+// mergeTask merges newer task into older one of the same service, the result applies
+// the desired config of newer task on the state older task starts from
+func mergeTask(older, newer Task) Task {
+	if newer.Drift == "" {
+		newer.Drift = older.Drift
+	}
+	switch {
+	case older.Type == DeleteTask:
+		// the service is being deleted, events after that are out of date
+		return older
+	case newer.Type == DeleteTask:
+		// config of older update may be not applied, delete the applied one
+		if older.Type == UpdateTask {
+			newer.NewConfig = older.OldConfig
+			newer.Driver = older.OldDriver
+			newer.OldDriver = older.OldDriver
+		}
+		return newer
+	case older.Type == ResyncTask && newer.Type == ResyncTask:
+		return newer
+	case older.Type == CreateTask || older.Type == ResyncTask:
+		newer.Type = CreateTask
+		newer.OldConfig = nil
+		newer.OldDriver = newer.Driver
+		return newer
+	default:
+		newer.Type = UpdateTask
+		newer.OldConfig = older.OldConfig
+		newer.OldDriver = older.OldDriver
+		return newer
+	}
+}
@@ -3,43 +3,119 @@ package lbctrl
 import (
 	"context"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/zdnscloud/elb-controller/driver"
 
 	"github.com/zdnscloud/gok8s/client"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 const (
+	// vip annotation value is an ipv4 or ipv6 address, or both separated by comma for dual-stack service
 	ZcloudLBVIPAnnotationKey    = "lb.zcloud.cn/vip"
 	ZcloudLBMethodAnnotationKey = "lb.zcloud.cn/method"
+
+	// healthcheck annotations apply to all ports, "<key>.<port>" overrides it for the port
+	ZcloudLBHealthCheckTypeAnnotationKey           = "lb.zcloud.cn/healthcheck-type"
+	ZcloudLBHealthCheckPathAnnotationKey           = "lb.zcloud.cn/healthcheck-path"
+	ZcloudLBHealthCheckExpectedStatusAnnotationKey = "lb.zcloud.cn/healthcheck-expected-status"
+	ZcloudLBHealthCheckIntervalAnnotationKey       = "lb.zcloud.cn/healthcheck-interval"
+	ZcloudLBHealthCheckTimeoutAnnotationKey        = "lb.zcloud.cn/healthcheck-timeout"
+	ZcloudLBHealthCheckRetriesAnnotationKey        = "lb.zcloud.cn/healthcheck-retries"
+
+	// max weight of a backend node when service externalTrafficPolicy is Local, 0 means no limit
+	ZcloudLBMaxWeightAnnotationKey = "lb.zcloud.cn/max-weight"
+
+	// persistence annotations override the one derived from service sessionAffinity
+	ZcloudLBPersistenceAnnotationKey        = "lb.zcloud.cn/persistence"
+	ZcloudLBPersistenceTimeoutAnnotationKey = "lb.zcloud.cn/persistence-timeout"
+	ZcloudLBPersistenceCookieAnnotationKey  = "lb.zcloud.cn/persistence-cookie"
+
+	// name of the kubernetes.io/tls secret in service namespace, "<key>.<port>" overrides it for the port
+	ZcloudLBTLSSecretAnnotationKey = "lb.zcloud.cn/tls-secret"
+
+	// "true" allows the vip to be shared with other services which set it too and use different ports
+	ZcloudLBSharedVIPAnnotationKey = "lb.zcloud.cn/shared-vip"
+
+	// "pod" sends traffic to pod ips and target ports directly, default "node" uses node ips and node ports
+	ZcloudLBBackendModeAnnotationKey = "lb.zcloud.cn/backend-mode"
+
+	// seconds removed backends are drained before deleted, default 0 means deleted immediately
+	ZcloudLBDrainSecondsAnnotationKey = "lb.zcloud.cn/drain-seconds"
+
+	// driver instance name, default driver is used if it's empty
+	ZcloudLBDriverAnnotationKey = "lb.zcloud.cn/driver"
+
+	// config applied by the last succeeded task, it's set by controller
+	ZcloudLBLastAppliedAnnotationKey = "lb.zcloud.cn/last-applied"
 )
 
-func genLBConfig(svc *corev1.Service, ep *corev1.Endpoints, clusterName string, nodeIpMap map[string]string) driver.Config {
+type nodeIPs struct {
+	IPv4 string
+	IPv6 string
+}
+
+// genLBConfig secrets are the tls secrets referred by svc, secret missing in it results tls without certificate
+func genLBConfig(svc *corev1.Service, ep *corev1.Endpoints, clusterName string, nodeIpMap map[string]nodeIPs, secrets map[string]*corev1.Secret) driver.Config {
+	// annotations are validated before config generated
+	vip, vipv6, _ := getLBConfigVIPs(svc)
 	result := driver.Config{
 		K8sCluster:   clusterName,
 		K8sNamespace: ep.Namespace,
 		K8sService:   ep.Name,
 		Services:     []driver.Service{},
-		VIP:          svc.Annotations[ZcloudLBVIPAnnotationKey],
+		VIP:          vip,
+		VIPv6:        vipv6,
 		Method:       getLBConfigMethod(svc),
 	}
+	result.Persistence, _ = getLBConfigPersistence(svc)
+	result.SourceRanges, _ = getLBConfigSourceRanges(svc)
+	result.BackendMode, _ = getLBConfigBackendMode(svc)
+	result.DrainSeconds, _ = getIntAnnotation(svc, ZcloudLBDrainSecondsAnnotationKey)
 
+	hosts, hostsV6 := getServiceNodesIP(nodeIpMap, ep)
+	weights := getServiceNodesWeight(svc, nodeIpMap, ep)
+	if vip == "" {
+		hosts = nil
+	}
+	if vipv6 == "" {
+		hostsV6 = nil
+	}
 	for _, port := range svc.Spec.Ports {
+		hc, _ := getLBConfigHealthCheck(svc, port.Port)
 		lbService := driver.Service{
-			Port:         port.Port,
-			BackendPort:  port.NodePort,
-			BackendHosts: getServiceNodesIP(nodeIpMap, ep),
-			Protocol:     getLBConfigProtocol(port.Protocol),
+			Port:           port.Port,
+			BackendPort:    port.NodePort,
+			BackendHosts:   hosts,
+			BackendHostsV6: hostsV6,
+			BackendWeights: weights,
+			Protocol:       getLBConfigProtocol(port.Protocol),
+			HealthCheck:    hc,
+			TLS:            getLBConfigTLS(svc, port.Port, secrets),
+		}
+		if result.BackendMode == driver.BackendModePod {
+			lbService.BackendHosts, lbService.BackendHostsV6, lbService.BackendPort = getServicePodsIP(ep, port)
+			lbService.BackendWeights = nil
+			if vip == "" {
+				lbService.BackendHosts = nil
+			}
+			if vipv6 == "" {
+				lbService.BackendHostsV6 = nil
+			}
 		}
 		result.Services = append(result.Services, lbService)
 	}
 	return result
 }
 
-func getServiceNodesIP(nodeIpMap map[string]string, ep *corev1.Endpoints) []string {
+func getServiceNodesIP(nodeIpMap map[string]nodeIPs, ep *corev1.Endpoints) ([]string, []string) {
 	if len(ep.Subsets) == 0 {
-		return nil
+		return nil, nil
 	}
 	nodes := make(map[string]bool)
 	for _, addr := range ep.Subsets[0].Addresses {
@@ -50,34 +126,178 @@ func getServiceNodesIP(nodeIpMap map[string]string, ep *corev1.Endpoints) []stri
 	}
 
 	ips := make([]string, 0)
+	ipv6s := make([]string, 0)
 	for key := range nodes {
-		ips = append(ips, nodeIpMap[key])
+		if ip := nodeIpMap[key].IPv4; ip != "" {
+			ips = append(ips, ip)
+		}
+		if ip := nodeIpMap[key].IPv6; ip != "" {
+			ipv6s = append(ipv6s, ip)
+		}
+	}
+	sort.Strings(ips)
+	sort.Strings(ipv6s)
+	return ips, ipv6s
+}
+
+// getServicePodsIP returns the ready endpoint ips and target port of the service port, when
+// subsets have different target port numbers, like during a rolling update of a named
+// port, only the first one is used. target port falls back to the number in service spec
+// when there is no endpoint
+func getServicePodsIP(ep *corev1.Endpoints, port corev1.ServicePort) ([]string, []string, int32) {
+	ips := make([]string, 0)
+	ipv6s := make([]string, 0)
+	var targetPort int32
+	for _, subset := range ep.Subsets {
+		for _, p := range subset.Ports {
+			if p.Name != port.Name || getLBConfigProtocol(p.Protocol) != getLBConfigProtocol(port.Protocol) {
+				continue
+			}
+			if targetPort == 0 {
+				targetPort = p.Port
+			}
+			if p.Port != targetPort {
+				break
+			}
+			for _, addr := range subset.Addresses {
+				if ip := net.ParseIP(addr.IP); ip != nil && ip.To4() != nil {
+					ips = append(ips, addr.IP)
+				} else if ip != nil {
+					ipv6s = append(ipv6s, addr.IP)
+				}
+			}
+			break
+		}
+	}
+	if targetPort == 0 {
+		targetPort = int32(port.TargetPort.IntValue())
+	}
+	sort.Strings(ips)
+	sort.Strings(ipv6s)
+	return ips, ipv6s, targetPort
+}
+
+// getServiceNodesWeight returns the weight of each node ip, which is the ready
+// endpoints number on the node, it's only meaningful when traffic isn't
+// redistributed by kube-proxy, that is externalTrafficPolicy is Local
+func getServiceNodesWeight(svc *corev1.Service, nodeIpMap map[string]nodeIPs, ep *corev1.Endpoints) map[string]int32 {
+	if svc.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal || len(ep.Subsets) == 0 {
+		return nil
+	}
+	maxWeight, _ := getIntAnnotation(svc, ZcloudLBMaxWeightAnnotationKey)
+
+	counts := make(map[string]int32)
+	for _, addr := range ep.Subsets[0].Addresses {
+		if addr.NodeName != nil {
+			counts[*addr.NodeName] += 1
+		}
+	}
+	for _, addr := range ep.Subsets[0].NotReadyAddresses {
+		if addr.NodeName == nil {
+			continue
+		}
+		if _, ok := counts[*addr.NodeName]; !ok {
+			counts[*addr.NodeName] = 1
+		}
+	}
+
+	weights := make(map[string]int32)
+	for node, w := range counts {
+		if maxWeight > 0 && w > maxWeight {
+			w = maxWeight
+		}
+		if ip := nodeIpMap[node].IPv4; ip != "" {
+			weights[ip] = w
+		}
+		if ip := nodeIpMap[node].IPv6; ip != "" {
+			weights[ip] = w
+		}
+	}
+	return weights
+}
+
+func getLBConfigVIPs(svc *corev1.Service) (string, string, error) {
+	var vip, vipv6 string
+	for _, v := range strings.Split(svc.Annotations[ZcloudLBVIPAnnotationKey], ",") {
+		v = strings.TrimSpace(v)
+		ip := net.ParseIP(v)
+		if ip == nil {
+			return "", "", fmt.Errorf("annotation %s value %s isn't an ip address", ZcloudLBVIPAnnotationKey, v)
+		}
+		if ip.To4() != nil {
+			if vip != "" {
+				return "", "", fmt.Errorf("annotation %s has more than one ipv4 address", ZcloudLBVIPAnnotationKey)
+			}
+			vip = v
+		} else {
+			if vipv6 != "" {
+				return "", "", fmt.Errorf("annotation %s has more than one ipv6 address", ZcloudLBVIPAnnotationKey)
+			}
+			vipv6 = v
+		}
+	}
+	return vip, vipv6, nil
+}
+
+func getLBDriverName(svc *corev1.Service) string {
+	return svc.Annotations[ZcloudLBDriverAnnotationKey]
+}
+
+func getLBConfigBackendMode(svc *corev1.Service) (driver.BackendMode, error) {
+	switch v := svc.Annotations[ZcloudLBBackendModeAnnotationKey]; v {
+	case "", string(driver.BackendModeNode):
+		return driver.BackendModeNode, nil
+	case string(driver.BackendModePod):
+		return driver.BackendModePod, nil
+	default:
+		return driver.BackendModeNode, fmt.Errorf("annotation %s value %s is unknown", ZcloudLBBackendModeAnnotationKey, v)
 	}
-	return ips
 }
 
 func getLBConfigProtocol(p corev1.Protocol) driver.Protocol {
-	if p == corev1.ProtocolUDP {
+	switch p {
+	case corev1.ProtocolUDP:
 		return driver.ProtocolUDP
+	case corev1.ProtocolSCTP:
+		return driver.ProtocolSCTP
+	default:
+		return driver.ProtocolTCP
 	}
-	return driver.ProtocolTCP
 }
 
-func getNodeIPMap(c client.Client) (map[string]string, error) {
+func getNodeIPMap(c client.Client) (map[string]nodeIPs, error) {
 	nl := &corev1.NodeList{}
 	if err := c.List(context.TODO(), &client.ListOptions{}, nl); err != nil {
 		return nil, err
 	}
 
-	nodes := make(map[string]string)
-	for _, n := range nl.Items {
-		for _, addr := range n.Status.Addresses {
-			if addr.Type == corev1.NodeInternalIP {
-				nodes[n.Name] = addr.Address
+	nodes := make(map[string]nodeIPs)
+	for i := range nl.Items {
+		nodes[nl.Items[i].Name] = getNodeIPs(&nl.Items[i])
+	}
+	return nodes, nil
+}
+
+// getNodeIPs returns the first internal ip of each family
+func getNodeIPs(n *corev1.Node) nodeIPs {
+	var ips nodeIPs
+	for _, addr := range n.Status.Addresses {
+		if addr.Type != corev1.NodeInternalIP {
+			continue
+		}
+		ip := net.ParseIP(addr.Address)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			if ips.IPv4 == "" {
+				ips.IPv4 = addr.Address
 			}
+		} else if ips.IPv6 == "" {
+			ips.IPv6 = addr.Address
 		}
 	}
-	return nodes, nil
+	return ips
 }
 
 func getLBConfigMethod(svc *corev1.Service) driver.LoadBalanceMethod {
@@ -91,6 +311,178 @@ func getLBConfigMethod(svc *corev1.Service) driver.LoadBalanceMethod {
 	}
 }
 
+func getLBConfigHealthCheck(svc *corev1.Service, port int32) (*driver.HealthCheck, error) {
+	t := getPortAnnotation(svc, ZcloudLBHealthCheckTypeAnnotationKey, port)
+	if t == "" {
+		return nil, nil
+	}
+
+	hc := &driver.HealthCheck{
+		Type: driver.HealthCheckType(t),
+		Path: getPortAnnotation(svc, ZcloudLBHealthCheckPathAnnotationKey, port),
+	}
+	switch hc.Type {
+	case driver.HealthCheckTCP, driver.HealthCheckUDP, driver.HealthCheckHTTP, driver.HealthCheckHTTPS, driver.HealthCheckICMP:
+	default:
+		return nil, fmt.Errorf("annotation %s value %s is unknown", ZcloudLBHealthCheckTypeAnnotationKey, t)
+	}
+
+	status, err := getPortIntAnnotation(svc, ZcloudLBHealthCheckExpectedStatusAnnotationKey, port)
+	if err != nil {
+		return nil, err
+	}
+	hc.ExpectedStatus = int(status)
+	if hc.Interval, err = getPortIntAnnotation(svc, ZcloudLBHealthCheckIntervalAnnotationKey, port); err != nil {
+		return nil, err
+	}
+	if hc.Timeout, err = getPortIntAnnotation(svc, ZcloudLBHealthCheckTimeoutAnnotationKey, port); err != nil {
+		return nil, err
+	}
+	if hc.Retries, err = getPortIntAnnotation(svc, ZcloudLBHealthCheckRetriesAnnotationKey, port); err != nil {
+		return nil, err
+	}
+	return hc, nil
+}
+
+func getLBConfigPersistence(svc *corev1.Service) (*driver.Persistence, error) {
+	p := &driver.Persistence{
+		Type: driver.PersistenceNone,
+	}
+	if svc.Spec.SessionAffinity == corev1.ServiceAffinityClientIP {
+		p.Type = driver.PersistenceClientIP
+		if c := svc.Spec.SessionAffinityConfig; c != nil && c.ClientIP != nil && c.ClientIP.TimeoutSeconds != nil {
+			p.Timeout = *c.ClientIP.TimeoutSeconds
+		}
+	}
+
+	if v := svc.Annotations[ZcloudLBPersistenceAnnotationKey]; v != "" {
+		switch driver.PersistenceType(v) {
+		case driver.PersistenceNone, driver.PersistenceClientIP, driver.PersistenceCookie:
+			p.Type = driver.PersistenceType(v)
+		default:
+			return nil, fmt.Errorf("annotation %s value %s is unknown", ZcloudLBPersistenceAnnotationKey, v)
+		}
+	}
+	if v := svc.Annotations[ZcloudLBPersistenceTimeoutAnnotationKey]; v != "" {
+		timeout, err := parseIntAnnotation(ZcloudLBPersistenceTimeoutAnnotationKey, v)
+		if err != nil {
+			return nil, err
+		}
+		p.Timeout = timeout
+	}
+	p.CookieName = svc.Annotations[ZcloudLBPersistenceCookieAnnotationKey]
+
+	if p.Type == driver.PersistenceNone {
+		return nil, nil
+	}
+	return p, nil
+}
+
+func getLBConfigTLS(svc *corev1.Service, port int32, secrets map[string]*corev1.Secret) *driver.TLS {
+	name := getPortAnnotation(svc, ZcloudLBTLSSecretAnnotationKey, port)
+	if name == "" {
+		return nil
+	}
+	tls := &driver.TLS{
+		SecretName: name,
+	}
+	if secret, ok := secrets[name]; ok {
+		tls.Certificate = string(secret.Data[corev1.TLSCertKey])
+		tls.Key = string(secret.Data[corev1.TLSPrivateKeyKey])
+	}
+	return tls
+}
+
+// getTLSSecretNames returns the names of tls secrets referred by svc
+func getTLSSecretNames(svc *corev1.Service) []string {
+	names := []string{}
+	seen := make(map[string]bool)
+	for _, port := range svc.Spec.Ports {
+		name := getPortAnnotation(svc, ZcloudLBTLSSecretAnnotationKey, port.Port)
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func getTLSSecrets(ctx context.Context, cli client.Client, svc *corev1.Service) (map[string]*corev1.Secret, error) {
+	secrets := make(map[string]*corev1.Secret)
+	for _, name := range getTLSSecretNames(svc) {
+		secret := &corev1.Secret{}
+		if err := cli.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: name}, secret); err != nil {
+			return nil, fmt.Errorf("get tls secret %s failed %s", name, err.Error())
+		}
+		if secret.Type != corev1.SecretTypeTLS {
+			return nil, fmt.Errorf("secret %s type %s isn't %s", name, secret.Type, corev1.SecretTypeTLS)
+		}
+		secrets[name] = secret
+	}
+	return secrets, nil
+}
+
+func isServiceReferSecret(svc *corev1.Service, name string) bool {
+	for _, n := range getTLSSecretNames(svc) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// getLBConfigSourceRanges returns the sorted and normalized service loadBalancerSourceRanges
+func getLBConfigSourceRanges(svc *corev1.Service) ([]string, error) {
+	if len(svc.Spec.LoadBalancerSourceRanges) == 0 {
+		return nil, nil
+	}
+	seen := make(map[string]bool)
+	ranges := []string{}
+	for _, r := range svc.Spec.LoadBalancerSourceRanges {
+		_, ipnet, err := net.ParseCIDR(strings.TrimSpace(r))
+		if err != nil {
+			return nil, fmt.Errorf("loadBalancerSourceRanges %s isn't a cidr", r)
+		}
+		if !seen[ipnet.String()] {
+			seen[ipnet.String()] = true
+			ranges = append(ranges, ipnet.String())
+		}
+	}
+	sort.Strings(ranges)
+	return ranges, nil
+}
+
+func getPortAnnotation(svc *corev1.Service, key string, port int32) string {
+	if v, ok := svc.Annotations[fmt.Sprintf("%s.%v", key, port)]; ok {
+		return v
+	}
+	return svc.Annotations[key]
+}
+
+func getPortIntAnnotation(svc *corev1.Service, key string, port int32) (int32, error) {
+	v := getPortAnnotation(svc, key, port)
+	if v == "" {
+		return 0, nil
+	}
+	return parseIntAnnotation(key, v)
+}
+
+func getIntAnnotation(svc *corev1.Service, key string) (int32, error) {
+	v := svc.Annotations[key]
+	if v == "" {
+		return 0, nil
+	}
+	return parseIntAnnotation(key, v)
+}
+
+func parseIntAnnotation(key, v string) (int32, error) {
+	i, err := strconv.ParseInt(v, 10, 32)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("annotation %s value %s isn't a non-negative integer", key, v)
+	}
+	return int32(i), nil
+}
+
 func isServiceNeedHandle(svc *corev1.Service) bool {
 	_, ok := svc.Annotations[ZcloudLBVIPAnnotationKey]
 	if isLoadBalancerService(svc) && ok {
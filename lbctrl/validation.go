@@ -0,0 +1,102 @@
+package lbctrl
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/zdnscloud/elb-controller/driver"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func validateService(svc *corev1.Service, caps driver.Capabilities) error {
+	_, vipv6, err := getLBConfigVIPs(svc)
+	if err != nil {
+		return err
+	}
+	if vipv6 != "" && !caps.SupportFeature(driver.FeatureIPv6) {
+		return fmt.Errorf("annotation %s value %s is an ipv6 address which driver doesn't support", ZcloudLBVIPAnnotationKey, vipv6)
+	}
+
+	if method := svc.Annotations[ZcloudLBMethodAnnotationKey]; method != "" {
+		if !caps.SupportMethod(driver.LoadBalanceMethod(method)) {
+			return fmt.Errorf("annotation %s value %s isn't supported by driver", ZcloudLBMethodAnnotationKey, method)
+		}
+	}
+
+	if _, err := getIntAnnotation(svc, ZcloudLBMaxWeightAnnotationKey); err != nil {
+		return err
+	}
+
+	p, err := getLBConfigPersistence(svc)
+	if err != nil {
+		return err
+	}
+	if p != nil {
+		if p.Type == driver.PersistenceClientIP && !caps.SupportFeature(driver.FeatureClientIPPersistence) ||
+			p.Type == driver.PersistenceCookie && !caps.SupportFeature(driver.FeatureCookiePersistence) {
+			return fmt.Errorf("persistence %s isn't supported by driver", p.Type)
+		}
+	}
+
+	mode, err := getLBConfigBackendMode(svc)
+	if err != nil {
+		return err
+	}
+	if mode == driver.BackendModePod && !caps.SupportFeature(driver.FeaturePodBackend) {
+		return fmt.Errorf("annotation %s value %s isn't supported by driver", ZcloudLBBackendModeAnnotationKey, mode)
+	}
+
+	drainSeconds, err := getIntAnnotation(svc, ZcloudLBDrainSecondsAnnotationKey)
+	if err != nil {
+		return err
+	}
+	if drainSeconds > 0 && !caps.SupportFeature(driver.FeatureBackendDrain) {
+		return fmt.Errorf("annotation %s isn't supported by driver", ZcloudLBDrainSecondsAnnotationKey)
+	}
+
+	ranges, err := getLBConfigSourceRanges(svc)
+	if err != nil {
+		return err
+	}
+	if len(ranges) > 0 && !caps.SupportFeature(driver.FeatureSourceRanges) {
+		return fmt.Errorf("loadBalancerSourceRanges isn't supported by driver")
+	}
+
+	for _, port := range svc.Spec.Ports {
+		if !caps.SupportProtocol(getLBConfigProtocol(port.Protocol)) {
+			return fmt.Errorf("port %v protocol %s isn't supported by driver", port.Port, port.Protocol)
+		}
+
+		hc, err := getLBConfigHealthCheck(svc, port.Port)
+		if err != nil {
+			return err
+		}
+		if hc != nil && !caps.SupportHealthCheck(hc.Type) {
+			return fmt.Errorf("port %v healthcheck type %s isn't supported by driver", port.Port, hc.Type)
+		}
+
+		if getPortAnnotation(svc, ZcloudLBTLSSecretAnnotationKey, port.Port) != "" {
+			if !caps.SupportFeature(driver.FeatureTLSOffload) {
+				return fmt.Errorf("port %v tls offload isn't supported by driver", port.Port)
+			}
+			if getLBConfigProtocol(port.Protocol) != driver.ProtocolTCP {
+				return fmt.Errorf("port %v tls offload is only for TCP protocol", port.Port)
+			}
+		}
+	}
+	return nil
+}
+
+// validateDrainSeconds makes sure the task has time left after draining, since
+// building, applying, removing backends and saving share the task timeout too
+func validateDrainSeconds(svc *corev1.Service, taskTimeout time.Duration) error {
+	drainSeconds, err := getIntAnnotation(svc, ZcloudLBDrainSecondsAnnotationKey)
+	if err != nil {
+		return err
+	}
+	if max := taskTimeout / 2; time.Duration(drainSeconds)*time.Second > max {
+		return fmt.Errorf("annotation %s value %v should be at most %v, half of task timeout %s", ZcloudLBDrainSecondsAnnotationKey, drainSeconds, int64(max/time.Second), taskTimeout)
+	}
+	return nil
+}
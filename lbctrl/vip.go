@@ -0,0 +1,119 @@
+package lbctrl
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zdnscloud/elb-controller/driver"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+type vipPort struct {
+	Driver   string
+	VIP      string
+	Protocol driver.Protocol
+	Port     int32
+}
+
+type vipOwner struct {
+	ports  []vipPort
+	shared bool
+}
+
+// vipAllocator tracks which service owns each vip port, a vip can be shared by
+// services only when all of them set the shared vip annotation and their ports
+// don't collide, vips of different drivers are on different loadbalancers so they never conflict
+type vipAllocator struct {
+	owners        map[string]vipOwner
+	defaultDriver string
+	lock          sync.Mutex
+}
+
+func newVIPAllocator(defaultDriver string) *vipAllocator {
+	return &vipAllocator{
+		owners:        make(map[string]vipOwner),
+		defaultDriver: defaultDriver,
+	}
+}
+
+// Assign records the vip ports of svc, the previous ones of svc are replaced, it
+// returns error without any change if svc conflicts with other services
+func (a *vipAllocator) Assign(svc *corev1.Service) error {
+	name := genObjNamespacedName(svc.Namespace, svc.Name)
+	owner := vipOwner{
+		ports:  getServiceVIPPorts(svc, a.defaultDriver),
+		shared: isServiceSharedVIP(svc),
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for other, o := range a.owners {
+		if other == name {
+			continue
+		}
+		for _, p := range owner.ports {
+			for _, op := range o.ports {
+				if p.VIP != op.VIP || p.Driver != op.Driver {
+					continue
+				}
+				if !owner.shared || !o.shared {
+					return fmt.Errorf("vip %s is used by service %s, services sharing vip should all set annotation %s to true", p.VIP, other, ZcloudLBSharedVIPAnnotationKey)
+				}
+				if p == op {
+					return fmt.Errorf("vip %s %s port %v is used by service %s", p.VIP, p.Protocol, p.Port, other)
+				}
+			}
+		}
+	}
+	a.owners[name] = owner
+	return nil
+}
+
+func (a *vipAllocator) Release(svc *corev1.Service) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	delete(a.owners, genObjNamespacedName(svc.Namespace, svc.Name))
+}
+
+// Init assigns the existing services, the older one wins if services conflict
+func (a *vipAllocator) Init(svcs []corev1.Service) {
+	sort.Slice(svcs, func(i, j int) bool {
+		return svcs[i].CreationTimestamp.Before(&svcs[j].CreationTimestamp)
+	})
+	for i := range svcs {
+		svc := &svcs[i]
+		if !isServiceNeedHandle(svc) || svc.DeletionTimestamp != nil {
+			continue
+		}
+		a.Assign(svc)
+	}
+}
+
+func getServiceVIPPorts(svc *corev1.Service, defaultDriver string) []vipPort {
+	driverName := getLBDriverName(svc)
+	if driverName == "" {
+		driverName = defaultDriver
+	}
+	vip, vipv6, _ := getLBConfigVIPs(svc)
+	ports := []vipPort{}
+	for _, v := range []string{vip, vipv6} {
+		if v == "" {
+			continue
+		}
+		for _, port := range svc.Spec.Ports {
+			ports = append(ports, vipPort{
+				Driver:   driverName,
+				VIP:      v,
+				Protocol: getLBConfigProtocol(port.Protocol),
+				Port:     port.Port,
+			})
+		}
+	}
+	return ports
+}
+
+func isServiceSharedVIP(svc *corev1.Service) bool {
+	return svc.Annotations[ZcloudLBSharedVIPAnnotationKey] == "true"
+}